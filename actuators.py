@@ -0,0 +1,72 @@
+# actuators.py
+import logging
+from abc import ABC, abstractmethod
+from typing import Dict
+import pins
+from clock import SYSTEM, Clock
+
+logger = logging.getLogger(__name__)
+
+
+class Actuator(ABC):
+    # Time source for dwell checks; replace per instance in tests
+    clock: Clock = SYSTEM
+
+    def __init__(self, name: str):
+        self._name = name
+        self.on = False
+        # Monotonic time of the last state change, for dwell checks
+        self.changed_at = float('-inf')
+
+    def name(self) -> str:
+        return self._name
+
+    def set(self, on: bool):
+        if on == self.on:
+            return
+        self._apply(on)
+        self.on = on
+        self.changed_at = self.clock.monotonic()
+
+    def seconds_in_state(self) -> float:
+        return self.clock.monotonic() - self.changed_at
+
+    @abstractmethod
+    def _apply(self, on: bool):
+        pass
+
+    def close(self):
+        pass
+
+    def status(self) -> Dict:
+        return {'name': self._name, 'on': self.on}
+
+
+class Relay(Actuator):
+    """A relay or MOSFET switched by a GPIO output."""
+    def __init__(self, name: str, pin_name: pins.PinId, active_low: bool = False):
+        super().__init__(name)
+        import digitalio
+        pin = pins.resolve(pin_name, output=True)
+        self.active_low = active_low
+        self.io = digitalio.DigitalInOut(pin)
+        self.io.direction = digitalio.Direction.OUTPUT
+        self.io.value = active_low
+
+    def _apply(self, on: bool):
+        self.io.value = on != self.active_low
+
+    def close(self):
+        self.io.value = self.active_low
+        self.io.deinit()
+
+
+class SimulatedActuator(Actuator):
+    def _apply(self, on: bool):
+        logger.info(f"[simulated] {self._name} -> {'on' if on else 'off'}")
+
+
+def create_actuator(name: str, options: Dict) -> Actuator:
+    if options.get("simulated", False):
+        return SimulatedActuator(name)
+    return Relay(name, options["pin"], active_low=options.get("active_low", False))
@@ -0,0 +1,69 @@
+# aggregate.py
+from statistics import mean
+from typing import Dict, List, Optional
+from clock import SYSTEM, Clock
+from sensors import Quality, SensorData, is_numeric
+
+FUNCTIONS = {
+    "mean": mean,
+    "min": min,
+    "max": max,
+    "last": lambda values: values[-1],
+    "count": len,
+}
+
+# The only functions that make sense for string fields
+NON_NUMERIC = {"last", "count"}
+
+
+class Aggregator:
+    """Collapses the readings of one sensor into one point per window.
+
+    Each field becomes `<field>_<function>` in the emitted reading, e.g.
+    lux_mean, lux_min, lux_max. The window is closed by the first reading
+    that arrives after it ends, and that reading starts the next window.
+    """
+    def __init__(self, window: float, functions: List[str] = None, clock: Clock = SYSTEM):
+        functions = functions or ["mean", "min", "max"]
+        unknown = set(functions) - set(FUNCTIONS)
+        if unknown:
+            raise ValueError(f"unknown aggregation function(s): {sorted(unknown)}")
+        self.window = window
+        self.clock = clock
+        self.functions = functions
+        self.readings: List[SensorData] = []
+        self.started = None
+
+    def add(self, data: SensorData) -> Optional[SensorData]:
+        now = self.clock.monotonic()
+        result = None
+        if self.started is not None and now - self.started >= self.window:
+            result = self.flush()
+        if self.started is None:
+            self.started = now
+        self.readings.append(data)
+        return result
+
+    def flush(self) -> Optional[SensorData]:
+        readings, self.readings, self.started = self.readings, [], None
+        if not readings:
+            return None
+
+        values: Dict[str, list] = {}
+        for data in readings:
+            for key, value in data.fields.items():
+                values.setdefault(key, []).append(value)
+
+        fields = {}
+        for key, series in values.items():
+            numeric = all(is_numeric(value) for value in series)
+            for name in self.functions:
+                if numeric:
+                    fields[f"{key}_{name}"] = float(FUNCTIONS[name](series))
+                elif name in NON_NUMERIC:
+                    fields[f"{key}_{name}"] = FUNCTIONS[name](series)
+        last = readings[-1]
+        quality = Quality.SMOOTHED
+        for data in readings:
+            quality |= data.quality
+        return SensorData(last.sensor_type, fields, timestamp=last.timestamp, tags=dict(last.tags), quality=quality)
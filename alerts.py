@@ -0,0 +1,277 @@
+# alerts.py
+import asyncio
+import logging
+import time
+from functools import partial
+from abc import ABC, abstractmethod
+from datetime import datetime
+from typing import Dict, List, Optional
+import aiohttp
+from retry import RetryPolicy, retry_async
+from sensors import SensorData, is_numeric
+
+logger = logging.getLogger(__name__)
+
+NOTIFY_TIMEOUT = aiohttp.ClientTimeout(total=10)
+
+
+class Alert:
+    """A threshold crossed, or with `recovered`, back within its limit."""
+    def __init__(self, sensor: str, field: str, value: float, threshold: float,
+                 direction: str, timestamp: datetime, recovered: bool = False):
+        self.sensor = sensor
+        self.field = field
+        self.value = value
+        self.threshold = threshold
+        self.direction = direction
+        self.timestamp = timestamp
+        self.recovered = recovered
+
+    def text(self) -> str:
+        when = self.timestamp.strftime("%Y-%m-%d %H:%M:%S")
+        if self.recovered:
+            return (f"✅ {self.sensor.upper()} {self.field} is back to {self.value:.2f} "
+                    f"(alert was {self.direction} {self.threshold}) at {when}")
+        return (f"⚠️ {self.sensor.upper()} {self.field} is {self.value:.2f} "
+                f"({self.direction} {self.threshold}) at {when}")
+
+    def to_dict(self) -> Dict:
+        d = {
+            'sensor': self.sensor,
+            'field': self.field,
+            'value': self.value,
+            'threshold': self.threshold,
+            'direction': self.direction,
+            'timestamp': self.timestamp.isoformat()
+        }
+        if self.recovered:
+            d['recovered'] = True
+        return d
+
+
+class TestAlert(Alert):
+    """Sent by the notifier self-check; shaped like an Alert so receivers parse it the same."""
+    def __init__(self, source: str, timestamp: datetime):
+        super().__init__(source, "test", 0.0, 0.0, "test", timestamp)
+
+    def text(self) -> str:
+        when = self.timestamp.strftime("%Y-%m-%d %H:%M:%S")
+        return f"✅ Test alert from {self.sensor} at {when}, notifications are working"
+
+    def to_dict(self) -> Dict:
+        return {**super().to_dict(), 'test': True}
+
+
+class Threshold:
+    """Raises an alert when a field crosses its limit.
+
+    Only the crossing alerts. With `above`, a value over it is a
+    violation, and the alert clears once the value is back at or under
+    above - hysteresis; `below` is the mirror image. A violation must
+    last `dwell_seconds` before the alert fires, and the value must stay
+    clear for `clear_dwell_seconds` (default: the same) before it clears,
+    judged by reading timestamps. In between the state is "pending" or
+    "recovering", and going back to where it came from sends nothing, so
+    a value oscillating around the limit doesn't flap. With
+    `notify_recovery` the clearing is sent as a recovered Alert too.
+    """
+    STATES = ("ok", "pending", "alerting", "recovering")
+
+    def __init__(self, sensor: str, field: str, above: Optional[float] = None,
+                 below: Optional[float] = None, hysteresis: float = 0, dwell_seconds: float = 0,
+                 clear_dwell_seconds: Optional[float] = None, notify_recovery: bool = False):
+        if not isinstance(sensor, str) or not isinstance(field, str):
+            raise ValueError(f"threshold sensor and field must be strings, got {sensor!r} and {field!r}")
+        if (above is None) == (below is None):
+            raise ValueError("threshold needs exactly one of 'above' or 'below'")
+        # A string limit would only fail later, on every reading compared with it
+        for name, value in (("above", above), ("below", below), ("hysteresis", hysteresis),
+                            ("dwell_seconds", dwell_seconds), ("clear_dwell_seconds", clear_dwell_seconds)):
+            if value is not None and (isinstance(value, bool) or not isinstance(value, (int, float))):
+                raise ValueError(f"threshold {name} must be a number, got {value!r}")
+        if hysteresis < 0 or dwell_seconds < 0 or (clear_dwell_seconds or 0) < 0:
+            raise ValueError("hysteresis and dwell times can't be negative")
+        self.sensor = sensor
+        self.field = field
+        self.above = above
+        self.below = below
+        self.hysteresis = hysteresis
+        self.dwell_seconds = dwell_seconds
+        self.clear_dwell_seconds = dwell_seconds if clear_dwell_seconds is None else clear_dwell_seconds
+        self.notify_recovery = notify_recovery
+        self.state = "ok"
+        # When the current pending or recovering state started
+        self.since: Optional[datetime] = None
+
+    @property
+    def active(self) -> bool:
+        """Whether the alert has fired and not cleared yet."""
+        return self.state in ("alerting", "recovering")
+
+    def key(self):
+        return (self.sensor, self.field, self.above, self.below)
+
+    def to_dict(self) -> Dict:
+        limit = {"above": self.above} if self.above is not None else {"below": self.below}
+        d = {"sensor": self.sensor, "field": self.field, **limit}
+        if self.hysteresis:
+            d["hysteresis"] = self.hysteresis
+        if self.dwell_seconds:
+            d["dwell_seconds"] = self.dwell_seconds
+        if self.clear_dwell_seconds != self.dwell_seconds:
+            d["clear_dwell_seconds"] = self.clear_dwell_seconds
+        if self.notify_recovery:
+            d["notify_recovery"] = True
+        return d
+
+    def _dwelt(self, now: datetime, seconds: float) -> bool:
+        return (now - self.since).total_seconds() >= seconds
+
+    def check(self, data: SensorData) -> Optional[Alert]:
+        if data.sensor_type != self.sensor or self.field not in data.fields:
+            return None
+        value = data.fields[self.field]
+        if not is_numeric(value):
+            return None
+        if self.above is not None:
+            limit, direction = self.above, "above"
+            violated, clear = value > self.above, value <= self.above - self.hysteresis
+        else:
+            limit, direction = self.below, "below"
+            violated, clear = value < self.below, value >= self.below + self.hysteresis
+        now = data.timestamp
+
+        if self.state in ("ok", "pending"):
+            if not violated:
+                self.state = "ok"
+                return None
+            if self.state == "ok":
+                self.state, self.since = "pending", now
+            if not self._dwelt(now, self.dwell_seconds):
+                return None
+            self.state = "alerting"
+            return Alert(self.sensor, self.field, value, limit, direction, now)
+
+        if not clear:
+            self.state = "alerting"
+            return None
+        if self.state == "alerting":
+            self.state, self.since = "recovering", now
+        if not self._dwelt(now, self.clear_dwell_seconds):
+            return None
+        self.state = "ok"
+        if self.notify_recovery:
+            return Alert(self.sensor, self.field, value, limit, direction, now, recovered=True)
+        return None
+
+
+class Notifier(ABC):
+    """Delivers an Alert, or anything else with text() and to_dict()
+    such as a period summary."""
+    @abstractmethod
+    async def notify(self, session: aiohttp.ClientSession, alert: Alert):
+        pass
+
+    def name(self) -> str:
+        return type(self).__name__
+
+
+class WebhookNotifier(Notifier):
+    def __init__(self, url: str):
+        self.url = url
+
+    async def notify(self, session, alert):
+        async with session.post(self.url, json=alert.to_dict()) as resp:
+            resp.raise_for_status()
+
+
+class TelegramNotifier(Notifier):
+    def __init__(self, bot_token: str, chat_id: str):
+        self.url = f"https://api.telegram.org/bot{bot_token}/sendMessage"
+        self.chat_id = chat_id
+
+    async def notify(self, session, alert):
+        async with session.post(self.url, json={"chat_id": self.chat_id, "text": alert.text()}) as resp:
+            resp.raise_for_status()
+
+
+class SlackNotifier(Notifier):
+    def __init__(self, webhook_url: str):
+        self.webhook_url = webhook_url
+
+    async def notify(self, session, alert):
+        async with session.post(self.webhook_url, json={"text": alert.text()}) as resp:
+            resp.raise_for_status()
+
+
+def create_notifier(options: Dict) -> Notifier:
+    kind = options.get("type")
+    if kind == "webhook":
+        return WebhookNotifier(options["url"])
+    if kind == "telegram":
+        return TelegramNotifier(options["bot_token"], str(options["chat_id"]))
+    if kind == "slack":
+        return SlackNotifier(options["webhook_url"])
+    raise ValueError(f"unknown notifier type {kind!r}")
+
+
+class Alerter:
+    """Checks readings against thresholds and fans alerts out to notifiers.
+
+    Each notifier is retried on its own per `retry`, so one flaky
+    destination doesn't hold up or repeat delivery to the others.
+    """
+    def __init__(self, thresholds: List[Threshold], notifiers: List[Notifier],
+                 retry: RetryPolicy = None):
+        self.thresholds = thresholds
+        self.notifiers = notifiers
+        self.retry = retry or RetryPolicy()
+
+    def check(self, data: SensorData):
+        for threshold in self.thresholds:
+            alert = threshold.check(data)
+            if alert:
+                if alert.recovered:
+                    logger.info(alert.text())
+                else:
+                    logger.warning(alert.text())
+                # Deliver in the background so the read loop never waits on the network
+                asyncio.create_task(self.dispatch(alert))
+
+    async def dispatch(self, alert: Alert):
+        if not self.notifiers:
+            return
+        async with aiohttp.ClientSession(timeout=NOTIFY_TIMEOUT) as session:
+            results = await asyncio.gather(
+                *(retry_async(partial(notifier.notify, session, alert), self.retry,
+                              what=f"{notifier.name()} delivery")
+                  for notifier in self.notifiers),
+                return_exceptions=True
+            )
+        for notifier, result in zip(self.notifiers, results):
+            if isinstance(result, Exception):
+                logger.error(f"✗ {notifier.name()} failed to deliver alert: {result}")
+
+    async def test(self, alert: Alert) -> List[Dict]:
+        """Sends `alert` once through every notifier, without retrying,
+        and reports how each one did, in the configured order.
+        """
+        async def attempt(index: int, notifier: Notifier, session) -> Dict:
+            result = {"index": index, "notifier": notifier.name(), "ok": True}
+            started = time.monotonic()
+            try:
+                await notifier.notify(session, alert)
+                logger.info(f"✓ {notifier.name()} delivered the test alert")
+            except aiohttp.ClientResponseError as e:
+                # Its str() has the URL, which for Telegram holds the bot token
+                result.update(ok=False, error=f"HTTP {e.status} {e.message}")
+            except Exception as e:
+                result.update(ok=False, error=str(e) or type(e).__name__)
+            if not result["ok"]:
+                logger.error(f"✗ {notifier.name()} failed to deliver the test alert: {result['error']}")
+            result["elapsed_ms"] = round((time.monotonic() - started) * 1000, 1)
+            return result
+
+        async with aiohttp.ClientSession(timeout=NOTIFY_TIMEOUT) as session:
+            return list(await asyncio.gather(*(attempt(index, notifier, session)
+                                               for index, notifier in enumerate(self.notifiers))))
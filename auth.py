@@ -0,0 +1,124 @@
+# auth.py
+import hmac
+import hashlib
+import importlib
+from abc import ABC, abstractmethod
+from dataclasses import dataclass
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional, Tuple
+from clock import SYSTEM, Clock
+
+
+class InvalidToken(ValueError):
+    pass
+
+
+@dataclass
+class Identity:
+    """Who a token belongs to.
+
+    `expires_at` is when the token stops being accepted, None if it doesn't;
+    only an identity with `admin` set may use /admin/*.
+    """
+    name: str
+    expires_at: Optional[datetime] = None
+    admin: bool = False
+
+
+class Authenticator(ABC):
+    """Checks the token a client sent on /api/*, /admin/* or the /ws handshake.
+
+    Called on the event loop for every request, so it must not block: a
+    backend asking another service should cache the answers it gets.
+    """
+    @abstractmethod
+    def authenticate(self, token: str) -> Identity:
+        """Who `token` belongs to. Raises InvalidToken."""
+        pass
+
+    def expires(self, token: str) -> Optional[datetime]:
+        return self.authenticate(token).expires_at
+
+    def valid(self, token: str) -> bool:
+        try:
+            self.authenticate(token)
+            return True
+        except InvalidToken:
+            return False
+
+
+class StaticToken(Authenticator):
+    """A fixed token such as API_TOKEN, which never expires and may use /admin/*."""
+    def __init__(self, token: str, name: str = "api_token", admin: bool = True):
+        self.token = token
+        self.name = name
+        self.admin = admin
+
+    def authenticate(self, token: str) -> Identity:
+        if not self.token or not hmac.compare_digest(token.encode("utf-8", "replace"), self.token.encode()):
+            raise InvalidToken("invalid token")
+        return Identity(self.name, admin=self.admin)
+
+
+class Tokens(Authenticator):
+    """Short-lived tokens issued against API_TOKEN.
+
+    An issued token is "<expiry in unix seconds>.<HMAC-SHA256 of that,
+    keyed by API_TOKEN>", so it is checked without keeping any state,
+    and every issued token stops working when API_TOKEN changes.
+    """
+    def __init__(self, secret: str, ttl: float, clock: Clock = SYSTEM):
+        self.secret = secret
+        self.ttl = ttl
+        self.clock = clock
+
+    def _sign(self, expiry: str) -> str:
+        return hmac.new(self.secret.encode(), expiry.encode(), hashlib.sha256).hexdigest()
+
+    def issue(self) -> Tuple[str, datetime]:
+        expires_at = (self.clock.now() + timedelta(seconds=self.ttl)).replace(microsecond=0)
+        expiry = str(int(expires_at.timestamp()))
+        return f"{expiry}.{self._sign(expiry)}", expires_at
+
+    def authenticate(self, token: str) -> Identity:
+        expiry, _, signature = token.encode("utf-8", "replace").decode().partition(".")
+        if (not self.secret or not expiry.isdigit()
+                or not hmac.compare_digest(signature.encode(), self._sign(expiry).encode())):
+            raise InvalidToken("invalid token")
+        expires_at = datetime.fromtimestamp(int(expiry), timezone.utc)
+        if expires_at <= self.clock.now():
+            raise InvalidToken("token expired")
+        return Identity("issued", expires_at=expires_at)
+
+
+class Chain(Authenticator):
+    """Backends tried in order; the first to accept a token wins.
+
+    A token none of them accepts is rejected as "invalid token", unless a
+    backend recognised it and said more, such as "token expired".
+    """
+    def __init__(self, backends: List[Authenticator]):
+        self.backends = backends
+
+    def authenticate(self, token: str) -> Identity:
+        error = InvalidToken("invalid token")
+        for backend in self.backends:
+            try:
+                return backend.authenticate(token)
+            except InvalidToken as e:
+                if str(error) == "invalid token":
+                    error = e
+        raise error
+
+
+def load_authenticator(spec: str) -> Authenticator:
+    """The backend named by "module:name", where name is an Authenticator
+    subclass or a function returning one, called without arguments.
+    """
+    module_name, _, attr = spec.partition(":")
+    if not module_name or not attr:
+        raise ValueError(f"expected module:name, got {spec!r}")
+    backend = getattr(importlib.import_module(module_name), attr)()
+    if not isinstance(backend, Authenticator):
+        raise ValueError(f"{spec} is not an Authenticator")
+    return backend
@@ -0,0 +1,84 @@
+# clock.py
+import time
+from datetime import datetime, timedelta, timezone
+from typing import Optional
+
+
+class Clock:
+    """Where sensors and the server get the time from.
+
+    `now` is wall-clock time for timestamps, `monotonic` measures
+    intervals (throttling, dwell times, windows). Code takes a Clock
+    instead of calling datetime.now()/time.monotonic() itself so tests
+    can drive time by hand with a FakeClock.
+    """
+    def now(self) -> datetime:
+        return datetime.now(timezone.utc)
+
+    def monotonic(self) -> float:
+        return time.monotonic()
+
+    def sleep(self, seconds: float):
+        time.sleep(seconds)
+
+
+class FakeClock(Clock):
+    """A clock that only moves when told to."""
+    def __init__(self, start: datetime = None):
+        self.current = start or datetime(2025, 1, 1, tzinfo=timezone.utc)
+        self.elapsed = 0.0
+
+    def now(self) -> datetime:
+        return self.current
+
+    def monotonic(self) -> float:
+        return self.elapsed
+
+    def advance(self, seconds: float):
+        self.current += timedelta(seconds=seconds)
+        self.elapsed += seconds
+
+    def sleep(self, seconds: float):
+        self.advance(seconds)
+
+    def set(self, when: datetime):
+        """Steps the wall clock, as NTP does, without moving monotonic time."""
+        self.current = when
+
+
+class ClockGuard:
+    """Keeps an eye on a wall clock that is wrong until NTP syncs (no RTC).
+
+    Times before `min_valid` are taken to be from before the sync. `check`
+    compares how far the wall clock moved since the last check with how
+    far monotonic time did, and reports a difference beyond
+    `jump_threshold` seconds as the clock having been stepped.
+    """
+    def __init__(self, clock: Clock, min_valid: Optional[datetime], jump_threshold: float = 60.0):
+        self.clock = clock
+        self.min_valid = min_valid
+        self.jump_threshold = jump_threshold
+        self.last_jump: Optional[float] = None
+        self.wall = clock.now()
+        self.mono = clock.monotonic()
+
+    def plausible(self, when: datetime = None) -> bool:
+        """Whether `when` (default: now) can be a real time."""
+        return self.min_valid is None or (when or self.clock.now()) >= self.min_valid
+
+    def check(self) -> Optional[float]:
+        """Seconds the wall clock was stepped by since the last check, if beyond the threshold."""
+        wall, mono = self.clock.now(), self.clock.monotonic()
+        jump = (wall - self.wall).total_seconds() - (mono - self.mono)
+        self.wall, self.mono = wall, mono
+        if abs(jump) <= self.jump_threshold:
+            return None
+        self.last_jump = jump
+        return jump
+
+    def restamp(self, at: float) -> datetime:
+        """The wall-clock time of monotonic time `at`, by the clock as it is now."""
+        return self.clock.now() - timedelta(seconds=self.clock.monotonic() - at)
+
+
+SYSTEM = Clock()
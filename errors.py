@@ -0,0 +1,85 @@
+# errors.py
+import logging
+from typing import Any, Optional
+from aiohttp import web
+
+logger = logging.getLogger(__name__)
+
+# Default error code for each status; handlers pass a more specific one where it helps
+STATUS_CODES = {
+    400: "bad_request",
+    401: "unauthorized",
+    403: "forbidden",
+    404: "not_found",
+    405: "method_not_allowed",
+    413: "too_large",
+    429: "rate_limited",
+    500: "internal",
+    502: "bad_gateway",
+    503: "unavailable",
+    504: "timeout",
+}
+
+
+def error_response(status: int, message: str, code: Optional[str] = None, details: Any = None,
+                   headers=None) -> web.Response:
+    """The error envelope every API endpoint answers with:
+    {"error": {"code": ..., "message": ...}}, plus "details" if given.
+
+    `code` is stable for clients to branch on; `message` is for people.
+    """
+    error = {"code": code or STATUS_CODES.get(status, "error"), "message": message}
+    if details is not None:
+        error["details"] = details
+    return web.json_response({"error": error}, status=status, headers=headers)
+
+
+class APIError(Exception):
+    """Raised by a handler to answer with an error envelope."""
+    def __init__(self, status: int, message: str, code: Optional[str] = None, details: Any = None):
+        super().__init__(message)
+        self.status = status
+        self.message = message
+        self.code = code
+        self.details = details
+
+    def response(self) -> web.Response:
+        return error_response(self.status, self.message, self.code, self.details)
+
+
+async def read_json(request, expected: type = dict, description: str = "a JSON object"):
+    """The request body, which must be JSON of type `expected`. Raises APIError (400)."""
+    try:
+        body = await request.json()
+    except ValueError:
+        raise APIError(400, f"request body is not valid JSON, expected {description}", "invalid_json")
+    if not isinstance(body, expected):
+        raise APIError(400, f"expected {description}", "invalid_body")
+    return body
+
+
+def api_path(path: str) -> bool:
+    return path.startswith('/api/') or path.startswith('/admin/')
+
+
+@web.middleware
+async def error_middleware(request, handler):
+    """Turns APIError, aiohttp's own HTTP errors (unknown path, wrong
+    method) and unexpected exceptions into error envelopes for /api and
+    /admin; other paths are left alone.
+    """
+    if not api_path(request.path):
+        return await handler(request)
+    try:
+        return await handler(request)
+    except APIError as e:
+        return e.response()
+    except web.HTTPException as e:
+        if e.status < 400:
+            raise
+        # Keep headers such as Allow on a 405
+        headers = {k: v for k, v in e.headers.items() if k.lower() not in ("content-type", "content-length")}
+        return error_response(e.status, e.reason, headers=headers)
+    except Exception as e:
+        logger.exception(f"Unhandled error in {request.method} {request.path}: {e}")
+        return error_response(500, "internal server error")
@@ -0,0 +1,191 @@
+# expressions.py
+import ast
+import math
+import operator
+from typing import Callable, Dict, Iterable, List, Mapping, Optional, Set
+from sensors import FieldValue
+
+# Keeps every expression cheap: there are no loops or definitions, so
+# evaluation is one pass over at most this many nodes
+MAX_LENGTH = 500
+MAX_NODES = 100
+
+
+class ExpressionError(ValueError):
+    pass
+
+
+def _pow(base, exponent):
+    # Float pow can only overflow; int pow could build an enormous number
+    return math.pow(base, exponent)
+
+
+def dew_point(temperature: float, humidity: float) -> float:
+    """Dew point in °C from temperature (°C) and relative humidity (%), Magnus formula."""
+    gamma = math.log(humidity / 100) + 17.62 * temperature / (243.12 + temperature)
+    return 243.12 * gamma / (17.62 - gamma)
+
+
+def heat_index(temperature: float, humidity: float) -> float:
+    """NOAA heat index in °C from temperature (°C) and relative humidity (%)."""
+    t = temperature * 9 / 5 + 32
+    simple = 0.5 * (t + 61 + (t - 68) * 1.2 + humidity * 0.094)
+    if (simple + t) / 2 >= 80:
+        t = (-42.379 + 2.04901523 * t + 10.14333127 * humidity - 0.22475541 * t * humidity
+             - 6.83783e-3 * t * t - 5.481717e-2 * humidity * humidity + 1.22874e-3 * t * t * humidity
+             + 8.5282e-4 * t * humidity * humidity - 1.99e-6 * t * t * humidity * humidity)
+    else:
+        t = simple
+    return (t - 32) * 5 / 9
+
+
+def thi(temperature: float, humidity: float) -> float:
+    """Temperature-humidity index for livestock, from °C and %."""
+    return 0.8 * temperature + humidity / 100 * (temperature - 14.4) + 46.4
+
+
+FUNCTIONS: Dict[str, Callable] = {
+    "abs": abs, "min": min, "max": max, "round": round,
+    "sqrt": math.sqrt, "exp": math.exp, "log": math.log, "log10": math.log10,
+    "floor": math.floor, "ceil": math.ceil,
+    "clamp": lambda value, low, high: min(max(value, low), high),
+    "dew_point": dew_point, "heat_index": heat_index, "thi": thi,
+}
+
+BINARY = {
+    ast.Add: operator.add, ast.Sub: operator.sub, ast.Mult: operator.mul, ast.Div: operator.truediv,
+    ast.FloorDiv: operator.floordiv, ast.Mod: operator.mod, ast.Pow: _pow,
+}
+UNARY = {ast.UAdd: operator.pos, ast.USub: operator.neg, ast.Not: operator.not_}
+COMPARE = {
+    ast.Eq: operator.eq, ast.NotEq: operator.ne, ast.Lt: operator.lt,
+    ast.LtE: operator.le, ast.Gt: operator.gt, ast.GtE: operator.ge,
+}
+
+
+class Expression:
+    """Arithmetic over a reading's fields, e.g. "raw * 0.1 + offset".
+
+    Numbers, field and constant names, + - * / // % **, comparisons,
+    and/or/not, "a if condition else b" and the calls in FUNCTIONS; nothing
+    else parses, so an expression can't reach Python itself. Raises
+    ExpressionError.
+    """
+    def __init__(self, source: str):
+        self.source = source
+        if len(source) > MAX_LENGTH:
+            raise ExpressionError(f"expression is longer than {MAX_LENGTH} characters")
+        try:
+            tree = ast.parse(source, mode="eval")
+        except SyntaxError as e:
+            raise ExpressionError(f"invalid expression {source!r}: {e.msg}")
+        nodes = list(ast.walk(tree))
+        if len(nodes) > MAX_NODES:
+            raise ExpressionError(f"expression {source!r} is too complex")
+        self.names: Set[str] = set()
+        called = {id(node.func) for node in nodes if isinstance(node, ast.Call)}
+        for node in nodes:
+            self._check(node, id(node) in called)
+        self.tree = tree.body
+
+    def _check(self, node, called: bool):
+        if isinstance(node, ast.Name):
+            if node.id in FUNCTIONS and not called:
+                raise ExpressionError(f"{node.id} is a function, in {self.source!r}")
+            if node.id not in FUNCTIONS:
+                self.names.add(node.id)
+        elif isinstance(node, ast.Constant):
+            if not isinstance(node.value, (int, float)):
+                raise ExpressionError(f"only numbers are allowed in {self.source!r}, got {node.value!r}")
+        elif isinstance(node, ast.Call):
+            if not isinstance(node.func, ast.Name) or node.func.id not in FUNCTIONS:
+                raise ExpressionError(f"unknown function in {self.source!r}, "
+                                      f"available: {', '.join(sorted(FUNCTIONS))}")
+            if node.keywords or any(isinstance(arg, ast.Starred) for arg in node.args):
+                raise ExpressionError(f"only positional arguments are allowed in {self.source!r}")
+        elif isinstance(node, (ast.BinOp, ast.UnaryOp, ast.Compare)):
+            ops = node.ops if isinstance(node, ast.Compare) else [node.op]
+            allowed = COMPARE if isinstance(node, ast.Compare) else BINARY if isinstance(node, ast.BinOp) else UNARY
+            for op in ops:
+                if type(op) not in allowed:
+                    raise ExpressionError(f"operator {type(op).__name__} is not allowed in {self.source!r}")
+        elif not isinstance(node, (ast.Expression, ast.BoolOp, ast.And, ast.Or, ast.IfExp, ast.Load,
+                                   *BINARY, *UNARY, *COMPARE)):
+            raise ExpressionError(f"{type(node).__name__} is not allowed in {self.source!r}")
+
+    def evaluate(self, variables: Mapping[str, FieldValue]):
+        """The value for `variables`; raises ArithmeticError, ValueError or TypeError."""
+        return self._eval(self.tree, variables)
+
+    def _eval(self, node, variables):
+        if isinstance(node, ast.Constant):
+            return node.value
+        if isinstance(node, ast.Name):
+            return variables[node.id]
+        if isinstance(node, ast.BinOp):
+            return BINARY[type(node.op)](self._eval(node.left, variables), self._eval(node.right, variables))
+        if isinstance(node, ast.UnaryOp):
+            return UNARY[type(node.op)](self._eval(node.operand, variables))
+        if isinstance(node, ast.BoolOp):
+            values = (self._eval(value, variables) for value in node.values)
+            return all(values) if isinstance(node.op, ast.And) else any(values)
+        if isinstance(node, ast.Compare):
+            left = self._eval(node.left, variables)
+            for op, comparator in zip(node.ops, node.comparators):
+                right = self._eval(comparator, variables)
+                if not COMPARE[type(op)](left, right):
+                    return False
+                left = right
+            return True
+        if isinstance(node, ast.IfExp):
+            branch = node.body if self._eval(node.test, variables) else node.orelse
+            return self._eval(branch, variables)
+        # Only calls are left after _check
+        return FUNCTIONS[node.func.id](*(self._eval(arg, variables) for arg in node.args))
+
+
+class FieldExpressions:
+    """New or replaced fields computed from a reading's other fields.
+
+    Evaluated in the order given, each seeing the fields computed before
+    it; `constants` are extra names, such as an offset. With `fields`, the
+    names a sensor can report, every name used is checked up front.
+    """
+    def __init__(self, expressions: Dict[str, str], constants: Optional[Dict[str, float]] = None,
+                 fields: Iterable[str] = ()):
+        self.constants = dict(constants or {})
+        for name, value in self.constants.items():
+            if isinstance(value, bool) or not isinstance(value, (int, float)):
+                raise ExpressionError(f"constant {name} must be a number, got {value!r}")
+        self.expressions: List = []
+        fields = set(fields)
+        known = fields | set(self.constants)
+        for field, source in expressions.items():
+            if not isinstance(source, str):
+                raise ExpressionError(f"expression for {field} must be a string, got {source!r}")
+            expression = Expression(source)
+            unknown = expression.names - known
+            if fields and unknown:
+                raise ExpressionError(f"expression for {field} uses unknown names: {', '.join(sorted(unknown))}")
+            self.expressions.append((field, expression))
+            known.add(field)
+
+    def apply(self, values: Dict[str, FieldValue]) -> List[str]:
+        """Sets the computed fields in `values` and returns the errors.
+
+        A field whose inputs aren't all in this reading, say because dedup
+        left one out, is skipped without counting as an error.
+        """
+        errors = []
+        for field, expression in self.expressions:
+            variables = {**self.constants, **values}
+            if not expression.names <= variables.keys():
+                continue
+            try:
+                value = expression.evaluate(variables)
+            except (ArithmeticError, ValueError, TypeError) as e:
+                errors.append(f"{field} = {expression.source}: {e}")
+                continue
+            # A number is always stored as a float; InfluxDB fixes a field's type on first write
+            values[field] = value if isinstance(value, bool) else float(value)
+        return errors
@@ -0,0 +1,92 @@
+# history.py
+from collections import deque
+from datetime import datetime, timedelta
+from statistics import mean
+from typing import Deque, Dict, Iterable, List, Optional, Tuple
+from clock import SYSTEM, Clock
+from sensors import SensorData, is_numeric
+
+
+class History:
+    """The last `size` readings of each sensor, kept in memory.
+
+    With `max_age` (seconds, 0 = no limit) readings older than that are
+    evicted too, so sensors read at different rates keep the same time
+    window; `size` still caps how many are kept. Both can be set per
+    sensor with configure().
+    """
+    def __init__(self, size: int, max_age: float = 0, clock: Clock = SYSTEM):
+        self.size = size
+        self.max_age = max_age
+        self.clock = clock
+        self.buffers: Dict[str, Deque[SensorData]] = {}
+        # Per-sensor (size, max_age) overriding the defaults above
+        self.limits: Dict[str, Tuple[int, float]] = {}
+
+    def configure(self, name: str, size: Optional[int] = None, max_age: Optional[float] = None):
+        size = self.size if size is None else size
+        max_age = self.max_age if max_age is None else max_age
+        self.limits[name] = (size, max_age)
+        buffer = self.buffers.get(name)
+        if buffer is not None and buffer.maxlen != size:
+            self.buffers[name] = deque(buffer, maxlen=size)
+
+    def limit(self, name: str) -> Tuple[int, float]:
+        return self.limits.get(name, (self.size, self.max_age))
+
+    def evict(self, name: str):
+        """Drops the readings of `name` older than its max_age."""
+        buffer = self.buffers.get(name)
+        max_age = self.limit(name)[1]
+        if not buffer or max_age <= 0:
+            return
+        cutoff = self.clock.now() - timedelta(seconds=max_age)
+        while buffer and buffer[0].timestamp < cutoff:
+            buffer.popleft()
+
+    def add(self, name: str, data: SensorData):
+        buffer = self.buffers.get(name)
+        if buffer is None:
+            buffer = self.buffers[name] = deque(maxlen=self.limit(name)[0])
+        buffer.append(data)
+        self.evict(name)
+
+    def recent(self, name: str, n: Optional[int] = None, since: Optional[datetime] = None) -> List[SensorData]:
+        """Up to n most recent readings (all if n is None), stamped at or
+        after `since` if given, oldest first.
+        """
+        self.evict(name)
+        readings = list(self.buffers.get(name, ()))
+        if since is not None:
+            readings = [data for data in readings if data.timestamp >= since]
+        if n is None:
+            return readings
+        n = max(0, min(n, self.limit(name)[0]))
+        return readings[-n:] if n else []
+
+    def retain(self, names):
+        """Forget the buffers of sensors not in `names`, and evict expired readings."""
+        names = set(names)
+        for name in set(self.buffers) - names:
+            del self.buffers[name]
+        for name in self.buffers:
+            self.evict(name)
+
+    def count(self) -> int:
+        return sum(len(buffer) for buffer in self.buffers.values())
+
+    def capacity(self, names: Iterable[str]) -> int:
+        return sum(self.limit(name)[0] for name in names)
+
+    def stats(self, name: str) -> Dict[str, Dict[str, float]]:
+        """Min, max and mean per numeric field over the buffered readings."""
+        values: Dict[str, List[float]] = {}
+        self.evict(name)
+        for data in self.buffers.get(name, ()):
+            for key, value in data.fields.items():
+                if is_numeric(value):
+                    values.setdefault(key, []).append(value)
+        return {
+            key: {"min": min(series), "max": max(series), "mean": mean(series), "count": len(series)}
+            for key, series in values.items()
+        }
@@ -0,0 +1,295 @@
+# hub.py
+import asyncio
+import json
+import logging
+from collections import deque
+from datetime import datetime
+from typing import Awaitable, Callable, Deque, Dict, List, Optional, Set, Tuple
+import metrics
+from clock import SYSTEM, Clock
+
+logger = logging.getLogger(__name__)
+
+# Messages queued per client before new ones are dropped
+SEND_BUFFER = 32
+# Messages waiting to be fanned out to the clients before the oldest are dropped
+BROADCAST_QUEUE = 1000
+
+
+def encode(envelope: Dict, legacy: bool) -> str:
+    # Legacy clients get readings as the bare SensorData object
+    if legacy and envelope.get("type") == "reading":
+        return json.dumps(envelope["data"])
+    return json.dumps(envelope)
+
+
+# Answers a client request {"type": ..., ...} with a reply envelope
+Handler = Callable[[Dict], Dict]
+
+# When a token expires (None: never); raises ValueError for a token that isn't valid
+Verifier = Callable[[str], Optional[datetime]]
+
+
+class Client:
+    """One connected consumer, fed through its own send queue.
+
+    `send` writes one encoded message to the underlying transport
+    (a WebSocket or an SSE stream); `close`, if given, shuts that
+    transport so the handler's read loop ends too. Replies to requests
+    go through the same queue as broadcasts, so its single writer never
+    interleaves two messages.
+
+    With `verify`, the client gets nothing and may only send
+    {"type": "auth", "token": ...} until a token is accepted; sending
+    another one later replaces it, e.g. before it expires. The caller
+    enforces `expires_at` and waits on `auth_changed`.
+    """
+    def __init__(self, send: Callable[[str], Awaitable], legacy: bool = False,
+                 remote: str = "", kind: str = "ws",
+                 close: Optional[Callable[[], Awaitable]] = None, send_buffer: int = SEND_BUFFER,
+                 handlers: Optional[Dict[str, Handler]] = None, verify: Optional[Verifier] = None,
+                 clock: Clock = SYSTEM):
+        self.send = send
+        self.handlers = handlers or {}
+        self.verify = verify
+        self.authenticated = verify is None
+        self.expires_at: Optional[datetime] = None
+        self.auth_changed = asyncio.Event()
+        self.close = close
+        # No more messages are queued once closed; the transport is closed at most once
+        self.closed = False
+        self.shut_down = False
+        # Set while write_loop runs; `send` must never be awaited anywhere else
+        self.writing = False
+        self.legacy = legacy
+        self.remote = remote
+        self.kind = kind
+        self.connected_at = clock.now().timestamp()
+        self.send_queue: asyncio.Queue = asyncio.Queue(maxsize=send_buffer)
+        # None means the client receives every sensor
+        self.subscriptions: Optional[Set[str]] = None
+
+    def wants(self, sensor_type: Optional[str]) -> bool:
+        if not self.authenticated:
+            return False
+        if sensor_type is None or self.subscriptions is None:
+            return True
+        return sensor_type in self.subscriptions
+
+    def handle_control(self, raw: str):
+        try:
+            message = json.loads(raw)
+        except ValueError:
+            logger.warning(f"Ignoring malformed control message: {raw!r}")
+            return
+
+        if not isinstance(message, dict):
+            return
+        if message.get("type") == "auth":
+            self.authenticate(message)
+            return
+        if not self.authenticated:
+            logger.warning(f"Ignoring message from unauthenticated client {self.remote}")
+            return
+        if "subscribe" in message:
+            sensors = message["subscribe"]
+            if isinstance(sensors, list) and sensors:
+                self.subscriptions = {str(s).lower() for s in sensors}
+            else:
+                self.subscriptions = None
+            logger.info(f"Client subscribed to: {sorted(self.subscriptions) if self.subscriptions else 'all'}")
+            return
+
+        handler = self.handlers.get(message.get("type"))
+        if handler is not None:
+            try:
+                reply = handler(message)
+            except Exception as e:
+                # A bad request gets an error reply, not a dropped connection
+                logger.exception(f"{message.get('type')} request from {self.remote} failed")
+                reply = {"type": message.get("type"), "error": str(e)}
+            # Lets a client with several requests outstanding match up the replies
+            if "id" in message:
+                reply["id"] = message["id"]
+            self.enqueue(json.dumps(reply))
+
+    def authenticate(self, message: Dict):
+        reply = {"type": "auth"}
+        try:
+            expires_at = self.verify(str(message.get("token", ""))) if self.verify else None
+        except ValueError as e:
+            # A failed refresh leaves the token accepted before in place until it expires
+            logger.warning(f"Rejected token from {self.remote}: {e}")
+            reply.update(status="rejected", error=str(e))
+        else:
+            self.authenticated = True
+            self.expires_at = expires_at
+            self.auth_changed.set()
+            reply.update(status="accepted", expires_at=expires_at.isoformat() if expires_at else None)
+        if "id" in message:
+            reply["id"] = message["id"]
+        self.enqueue(json.dumps(reply))
+
+    def enqueue(self, message: str):
+        if self.closed:
+            return
+        try:
+            self.send_queue.put_nowait(message)
+        except asyncio.QueueFull:
+            logger.warning("Client send buffer full, dropping message")
+
+    async def write_loop(self):
+        # aiohttp, like most WebSocket libraries, doesn't allow two concurrent writers
+        if self.writing:
+            raise RuntimeError("client already has a writer")
+        self.writing = True
+        while True:
+            message = await self.send_queue.get()
+            try:
+                await self.send(message)
+            except Exception as e:
+                # The connection is gone: stop queueing for it until it is unregistered
+                logger.info(f"Write to client failed: {e}")
+                self.closed = True
+                return
+
+    async def shutdown(self, **kwargs):
+        """Closes the transport, once, however many parts of the server ask for it."""
+        if self.shut_down or self.close is None:
+            return
+        self.shut_down = True
+        self.closed = True
+        try:
+            await self.close(**kwargs)
+        except Exception as e:
+            logger.info(f"Closing client failed: {e}")
+
+
+class Hub:
+    """Connected clients and what is sent to them.
+
+    Messages go through one queue of up to `queue_size`, which `run`
+    fans out to the clients' own queues; when a burst fills it the
+    oldest message is dropped. With `coalesce` > 0, `run` waits that
+    many seconds between rounds and readings published in between are
+    sent as one {"type": "batch", "data": [...]} message.
+    """
+    def __init__(self, coalesce: float = 0, send_buffer: int = SEND_BUFFER, queue_size: int = BROADCAST_QUEUE,
+                 clock: Clock = SYSTEM):
+        self.clients: Set[Client] = set()
+        self.clock = clock
+        self.coalesce = coalesce
+        self.send_buffer = send_buffer
+        # Requests clients may send, by type
+        self.handlers: Dict[str, Handler] = {}
+        # (kind, message, sensor_type): kind "reading" for readings, which may be batched
+        self.queue: Deque[Tuple[str, Dict, Optional[str]]] = deque(maxlen=queue_size)
+        self.queued: Optional[asyncio.Event] = None
+
+    def register(self, send: Callable[[str], Awaitable], legacy: bool = False,
+                 remote: str = "", kind: str = "ws",
+                 close: Optional[Callable[[], Awaitable]] = None, verify: Optional[Verifier] = None) -> Client:
+        client = Client(send, legacy, remote, kind, close, self.send_buffer, self.handlers, verify, self.clock)
+        self.clients.add(client)
+        logger.info(f"Client connected. Total clients: {len(self.clients)}")
+        return client
+
+    def unregister(self, client: Client):
+        # Safe to call more than once; nothing is queued for the client afterwards
+        client.closed = True
+        if client in self.clients:
+            self.clients.discard(client)
+            logger.info(f"Client disconnected. Total clients: {len(self.clients)}")
+
+    async def close_all(self):
+        """Close every client's transport, e.g. on server shutdown."""
+        for client in list(self.clients):
+            await client.shutdown()
+
+    def describe(self) -> List[Dict]:
+        return [
+            {
+                "remote": client.remote,
+                "kind": client.kind,
+                "legacy": client.legacy,
+                "connected_at": client.connected_at,
+                "authenticated": client.authenticated,
+                "subscriptions": sorted(client.subscriptions) if client.subscriptions else None
+            }
+            for client in self.clients
+        ]
+
+    def _post(self, kind: str, message: Dict, sensor_type: Optional[str]):
+        if len(self.queue) == self.queue.maxlen:
+            metrics.broadcast_dropped.inc()
+            logger.warning("Broadcast queue full, dropping the oldest message")
+        self.queue.append((kind, message, sensor_type))
+        if self.queued is not None:
+            self.queued.set()
+
+    def broadcast(self, envelope: Dict, sensor_type: Optional[str] = None):
+        """Queue a typed message for every client subscribed to sensor_type.
+
+        Messages without a sensor_type (heartbeats and other server
+        messages) go to all clients.
+        """
+        self._post("message", envelope, sensor_type)
+
+    def publish_reading(self, reading: Dict, sensor_type: str):
+        self._post("reading", reading, sensor_type)
+
+    async def run(self):
+        """Fans queued messages out to the clients, in order, until cancelled."""
+        self.queued = asyncio.Event()
+        # Posted before the loop started
+        if self.queue:
+            self.queued.set()
+        while True:
+            await self.queued.wait()
+            if self.coalesce > 0:
+                await asyncio.sleep(self.coalesce)
+            self.queued.clear()
+            items, readings = list(self.queue), []
+            self.queue.clear()
+            for kind, message, sensor_type in items:
+                if kind == "reading" and self.coalesce > 0:
+                    readings.append((message, sensor_type))
+                    continue
+                # Readings batched so far go out before the message that followed them
+                self.send_readings(readings)
+                readings = []
+                if kind == "reading":
+                    self.fan_out({"type": "reading", "data": message}, sensor_type)
+                else:
+                    self.fan_out(message, sensor_type)
+            self.send_readings(readings)
+
+    def fan_out(self, envelope: Dict, sensor_type: Optional[str]):
+        # Each wire format is encoded once
+        encoded: Dict[bool, str] = {}
+        for client in self.clients:
+            if not client.wants(sensor_type):
+                continue
+            if client.legacy not in encoded:
+                encoded[client.legacy] = encode(envelope, client.legacy)
+            client.enqueue(encoded[client.legacy])
+
+    def send_readings(self, pending: List[Tuple[Dict, Optional[str]]]):
+        if not pending:
+            return
+        # Clients with the same subscriptions and format share one encoding
+        encoded: Dict[Tuple, List[str]] = {}
+        for client in self.clients:
+            wanted = tuple(i for i, (_, sensor_type) in enumerate(pending) if client.wants(sensor_type))
+            if not wanted:
+                continue
+            key = (client.legacy, wanted)
+            if key not in encoded:
+                readings = [pending[i][0] for i in wanted]
+                if client.legacy or len(readings) == 1:
+                    # Legacy clients only understand one bare reading per message
+                    encoded[key] = [encode({"type": "reading", "data": r}, client.legacy) for r in readings]
+                else:
+                    encoded[key] = [json.dumps({"type": "batch", "data": readings})]
+            for message in encoded[key]:
+                client.enqueue(message)
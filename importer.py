@@ -0,0 +1,120 @@
+# importer.py
+import os
+import gzip
+import json
+import time
+import logging
+from datetime import datetime
+from typing import Callable, Dict, Iterator, List, Optional, Tuple
+from sensors import SensorData
+from sinks import Sink
+
+logger = logging.getLogger(__name__)
+
+
+class ImportState:
+    """What has already been imported, so an interrupted import can resume.
+
+    Kept per file and per series (device, sensor, measurement) as the
+    newest (timestamp, seq) written. Readings at or before that mark are
+    skipped; the seq tells apart readings stamped within the same
+    instant. Only marks saved by earlier runs count, so readings that
+    are out of order within the file are still imported the first time.
+    """
+    def __init__(self, path: str):
+        self.path = path
+        self.marks: Dict[str, Dict[str, List]] = {}
+        if os.path.exists(path):
+            with open(path) as f:
+                self.marks = json.load(f)
+        self.done = {file: dict(series) for file, series in self.marks.items()}
+
+    @staticmethod
+    def _series(data: SensorData) -> str:
+        return f"{data.tags.get('device', '')}/{data.sensor_type}/{data.measurement or ''}"
+
+    @staticmethod
+    def _mark(data: SensorData) -> List:
+        return [data.timestamp.isoformat(), data.seq or 0]
+
+    def seen(self, file: str, data: SensorData) -> bool:
+        mark = self.done.get(file, {}).get(self._series(data))
+        return mark is not None and self._key(self._mark(data)) <= self._key(mark)
+
+    def add(self, file: str, data: SensorData):
+        series = self.marks.setdefault(file, {})
+        key = self._series(data)
+        mark = self._mark(data)
+        if key not in series or self._key(mark) > self._key(series[key]):
+            series[key] = mark
+
+    @staticmethod
+    def _key(mark: List) -> Tuple[datetime, int]:
+        return datetime.fromisoformat(mark[0]), mark[1]
+
+    def save(self):
+        # Written whole and swapped in, so a crash can't leave half a file
+        tmp = self.path + ".tmp"
+        with open(tmp, "w") as f:
+            json.dump(self.marks, f, indent=2)
+        os.replace(tmp, self.path)
+
+
+def read_ndjson(path: str) -> Iterator[Tuple[Optional[SensorData], float]]:
+    """Each line of `path` as a reading (None if malformed), with the fraction of the file read."""
+    size = os.path.getsize(path) or 1
+    with open(path, "rb") as raw:
+        # Rotated files are gzipped by the file sink; progress is measured on the compressed size
+        lines = gzip.GzipFile(fileobj=raw) if path.endswith(".gz") else raw
+        for line in lines:
+            if not line.strip():
+                continue
+            try:
+                data = SensorData.from_dict(json.loads(line))
+            except (ValueError, KeyError, TypeError, AttributeError):
+                data = None
+            yield data, raw.tell() / size
+
+
+def import_file(path: str, sinks: List[Sink], state: ImportState,
+                plausible: Callable[[SensorData], bool] = lambda data: True,
+                batch_size: int = 500, progress_interval: float = 5.0) -> Dict[str, int]:
+    """Writes the readings in an NDJSON file to `sinks`, keeping their timestamps.
+
+    Malformed lines and readings `plausible` rejects (e.g. stamped before
+    the clock synced) are skipped and counted. State is saved after each
+    batch; a batch a sink fails to store raises, leaving the state at the
+    last batch that was stored.
+    """
+    file = os.path.abspath(path)
+    counts = {"imported": 0, "skipped": 0, "malformed": 0, "implausible": 0}
+    batch: List[SensorData] = []
+    next_report = time.monotonic() + progress_interval
+
+    def write():
+        for sink in sinks:
+            sink.write_batch(batch)
+        for data in batch:
+            state.add(file, data)
+        state.save()
+        counts["imported"] += len(batch)
+        batch.clear()
+
+    for data, fraction in read_ndjson(path):
+        if data is None:
+            counts["malformed"] += 1
+        elif state.seen(file, data):
+            counts["skipped"] += 1
+        elif not plausible(data):
+            counts["implausible"] += 1
+        else:
+            batch.append(data)
+            if len(batch) >= batch_size:
+                write()
+        if time.monotonic() >= next_report:
+            logger.info(f"{path}: {fraction:.0%}, {counts}")
+            next_report = time.monotonic() + progress_interval
+    if batch:
+        write()
+    logger.info(f"✓ {path}: {counts}")
+    return counts
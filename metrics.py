@@ -0,0 +1,41 @@
+# metrics.py
+from prometheus_client import Counter, Gauge
+
+# Prometheus metrics, served at /metrics
+ws_clients = Gauge("iotgo_clients", "Connected WebSocket and SSE clients")
+
+# Per sensor, labelled by sensor name and type. The timestamp only moves
+# on a successful reading, so a failing sensor shows up as a stale gauge.
+SENSOR_LABELS = ["sensor", "type"]
+sensor_last_reading = Gauge("iotgo_sensor_last_reading_timestamp_seconds",
+                            "Unix time of the last successful reading", SENSOR_LABELS)
+sensor_reads = Counter("iotgo_sensor_reads_total", "Reads attempted", SENSOR_LABELS)
+sensor_read_errors = Counter("iotgo_sensor_read_errors_total", "Reads that failed or timed out", SENSOR_LABELS)
+
+
+# In-memory buffers, labelled by buffer name, updated by the janitor
+buffer_items = Gauge("iotgo_buffer_items", "Items held in an in-memory buffer", ["buffer"])
+buffer_capacity = Gauge("iotgo_buffer_capacity", "Most items an in-memory buffer may hold", ["buffer"])
+
+
+# Messages waiting to be fanned out to WebSocket and SSE clients
+broadcast_queue_depth = Gauge("iotgo_broadcast_queue_depth", "Messages waiting to be sent to clients")
+broadcast_dropped = Counter("iotgo_broadcast_dropped_total", "Messages dropped because the broadcast queue was full")
+
+
+# Per storage backend, labelled by sink name
+write_queue_depth = Gauge("iotgo_write_queue_depth", "Readings waiting to be written", ["sink"])
+write_dropped = Counter("iotgo_write_dropped_total", "Readings dropped because the write queue was full", ["sink"])
+write_errors = Counter("iotgo_write_errors_total", "Writes that failed", ["sink"])
+write_healthy = Gauge("iotgo_write_healthy", "1 unless the last write failed or one is stalled", ["sink"])
+
+
+def sensor_labels(sensor):
+    return {"sensor": sensor.name(), "type": sensor.metadata()["type"]}
+
+
+def register_sensor(sensor):
+    # Create the series up front so error rates are defined before the first failure
+    labels = sensor_labels(sensor)
+    sensor_reads.labels(**labels)
+    sensor_read_errors.labels(**labels)
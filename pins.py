@@ -0,0 +1,99 @@
+# pins.py
+from typing import Union
+
+PinId = Union[int, str]
+
+# Raspberry Pi 40-pin header: physical pin -> BCM GPIO number
+HEADER = {
+    3: 2, 5: 3, 7: 4, 8: 14, 10: 15, 11: 17, 12: 18, 13: 27, 15: 22, 16: 23,
+    18: 24, 19: 10, 21: 9, 22: 25, 23: 11, 24: 8, 26: 7, 27: 0, 28: 1, 29: 5,
+    31: 6, 32: 12, 33: 13, 35: 19, 36: 16, 37: 26, 38: 20, 40: 21,
+}
+# The rest of the header, named in errors
+POWER = {1: "3.3V", 17: "3.3V", 2: "5V", 4: "5V",
+         6: "GND", 9: "GND", 14: "GND", 20: "GND", 25: "GND", 30: "GND", 34: "GND", 39: "GND"}
+# Function names printed on most pinout diagrams
+ALIASES = {
+    "SDA": 2, "SCL": 3, "TXD": 14, "TX": 14, "RXD": 15, "RX": 15,
+    "MOSI": 10, "MISO": 9, "SCLK": 11, "SCK": 11, "CE0": 8, "CE1": 7,
+    "ID_SD": 0, "ID_SC": 1,
+}
+GPIO_COUNT = 28
+
+PHYSICAL_PREFIXES = ("PIN", "PHYS", "BOARD", "P1-")
+BCM_PREFIXES = ("GPIO", "BCM", "D")
+
+
+def _physical(number: int) -> int:
+    if number in POWER:
+        raise ValueError(f"header pin {number} is {POWER[number]}, not a GPIO")
+    if number not in HEADER:
+        raise ValueError(f"there is no header pin {number}, the header has pins 1-40")
+    return HEADER[number]
+
+
+def bcm(value: PinId) -> int:
+    """The BCM GPIO number `value` stands for. Raises ValueError.
+
+    A bare number is a BCM number (4, "4"), as are "GPIO4", "BCM4" and
+    Blinka's "D4"; "PIN7", "PHYS7", "BOARD7" and "P1-7" are physical header
+    pins (also GPIO4); and names such as SDA, TXD or CE0 are their usual GPIO.
+    Case and spaces don't matter.
+    """
+    if isinstance(value, bool):
+        raise ValueError(f"unknown pin {value!r}")
+    if isinstance(value, int):
+        number = value
+    else:
+        text = str(value).strip().upper().replace(" ", "")
+        if text in ALIASES:
+            return ALIASES[text]
+        for prefix in PHYSICAL_PREFIXES:
+            if text.startswith(prefix) and text[len(prefix):].isdigit():
+                return _physical(int(text[len(prefix):]))
+        for prefix in BCM_PREFIXES:
+            if text.startswith(prefix) and text[len(prefix):].lstrip("-").isdigit():
+                text = text[len(prefix):]
+                break
+        try:
+            number = int(text)
+        except ValueError:
+            raise ValueError(f"unknown pin {value!r}, expected a GPIO number (4, GPIO4, BCM4, D4), "
+                             f"a header pin (PIN7) or a name such as SDA")
+    if not 0 <= number < GPIO_COUNT:
+        raise ValueError(f"GPIO{number} doesn't exist, the header has GPIO0-GPIO{GPIO_COUNT - 1}")
+    return number
+
+
+def name(value: PinId) -> str:
+    """The canonical name of `value`, e.g. "GPIO4" for 4, "D4" or "PIN7"."""
+    return f"GPIO{bcm(value)}"
+
+
+def resolve(value: PinId, input_pull_up: bool = False, output: bool = False):
+    """The board pin for `value`, after briefly claiming it to check it
+    can be an input with pull-up and/or an output, as asked. Raises
+    ValueError naming the pin and what it couldn't do.
+    """
+    import board
+    import digitalio
+    pin_name = name(value)
+    pin = getattr(board, f"D{pin_name[4:]}", None)
+    if pin is None:
+        raise ValueError(f"{pin_name} isn't available on this board")
+    mode = "an input with pull-up" if input_pull_up else "an output"
+    try:
+        io = digitalio.DigitalInOut(pin)
+    except Exception as e:
+        raise ValueError(f"can't open {pin_name}, is it used by something else? {e}") from e
+    try:
+        if input_pull_up:
+            io.switch_to_input(pull=digitalio.Pull.UP)
+        if output:
+            mode = "an output"
+            io.switch_to_output()
+    except Exception as e:
+        raise ValueError(f"{pin_name} can't be used as {mode}: {e}") from e
+    finally:
+        io.deinit()
+    return pin
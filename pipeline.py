@@ -0,0 +1,55 @@
+# pipeline.py
+from abc import ABC, abstractmethod
+from typing import Callable, Dict, Iterable, List, Optional
+from sensors import Sensor, SensorData
+
+
+class Stage(ABC):
+    """One step a reading goes through on its way from the sensor to storage and clients.
+
+    A stage may change the reading in place, return another one, or
+    return None to drop it, which ends the pipeline for that reading.
+    """
+    name = ""
+
+    @abstractmethod
+    def process(self, sensor: Sensor, data: SensorData) -> Optional[SensorData]:
+        pass
+
+
+class FunctionStage(Stage):
+    """A stage that is just a function of (sensor, reading)."""
+    def __init__(self, name: str, fn: Callable[[Sensor, SensorData], Optional[SensorData]]):
+        self.name = name
+        self.fn = fn
+
+    def process(self, sensor: Sensor, data: SensorData) -> Optional[SensorData]:
+        return self.fn(sensor, data)
+
+
+class Pipeline:
+    """Stages run in order; a stage returning None drops the reading."""
+    def __init__(self, stages: List[Stage]):
+        self.stages = stages
+
+    @classmethod
+    def from_names(cls, names: Iterable[str], available: Dict[str, Stage]) -> "Pipeline":
+        """The stages called `names`, in that order, out of `available`. Raises ValueError."""
+        names = list(names)
+        unknown = [name for name in names if name not in available]
+        if unknown:
+            raise ValueError(f"unknown stage(s) {', '.join(unknown)}, available: {', '.join(available)}")
+        repeated = sorted({name for name in names if names.count(name) > 1})
+        if repeated:
+            raise ValueError(f"stage(s) listed twice: {', '.join(repeated)}")
+        return cls([available[name] for name in names])
+
+    def run(self, sensor: Sensor, data: SensorData) -> Optional[SensorData]:
+        for stage in self.stages:
+            data = stage.process(sensor, data)
+            if data is None:
+                return None
+        return data
+
+    def names(self) -> List[str]:
+        return [stage.name for stage in self.stages]
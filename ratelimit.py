@@ -0,0 +1,53 @@
+# ratelimit.py
+from typing import Dict
+from clock import SYSTEM, Clock
+
+
+class TokenBucket:
+    def __init__(self, rate: float, burst: int, clock: Clock = SYSTEM):
+        self.rate = rate
+        self.burst = burst
+        self.clock = clock
+        self.tokens = float(burst)
+        self.updated = clock.monotonic()
+
+    def allow(self) -> bool:
+        now = self.clock.monotonic()
+        self.tokens = min(self.burst, self.tokens + (now - self.updated) * self.rate)
+        self.updated = now
+        if self.tokens >= 1:
+            self.tokens -= 1
+            return True
+        return False
+
+
+class RateLimiter:
+    """Keeps one token bucket per key (client IP)."""
+
+    # Forget idle keys once this many are tracked
+    MAX_KEYS = 1024
+
+    def __init__(self, rate: float, burst: int, max_keys: int = MAX_KEYS, clock: Clock = SYSTEM):
+        self.rate = rate
+        self.burst = burst
+        self.max_keys = max_keys
+        self.clock = clock
+        self.buckets: Dict[str, TokenBucket] = {}
+
+    def allow(self, key: str) -> bool:
+        bucket = self.buckets.get(key)
+        if bucket is None:
+            if len(self.buckets) >= self.max_keys:
+                self.prune()
+            bucket = self.buckets[key] = TokenBucket(self.rate, self.burst, self.clock)
+        return bucket.allow()
+
+    def prune(self):
+        # A bucket idle long enough to refill completely carries no state
+        idle = self.burst / self.rate if self.rate > 0 else 0
+        cutoff = self.clock.monotonic() - idle
+        self.buckets = {k: b for k, b in self.buckets.items() if b.updated > cutoff}
+        if len(self.buckets) > self.max_keys:
+            # Still too many busy keys: keep the most recently used
+            newest = sorted(self.buckets.items(), key=lambda item: item[1].updated)[-self.max_keys:]
+            self.buckets = dict(newest)
@@ -0,0 +1,79 @@
+# retry.py
+import time
+import random
+import asyncio
+import logging
+import threading
+from typing import Awaitable, Callable, Dict, Optional, Tuple, Type, TypeVar
+
+logger = logging.getLogger(__name__)
+
+T = TypeVar("T")
+
+
+class RetryPolicy:
+    """How often and how patiently to retry a failing call.
+
+    The delay after the n-th failure is base_delay * multiplier**(n-1),
+    capped at max_delay and then spread by +-jitter (a fraction) so
+    many callers retrying at once don't stay in lockstep.
+    """
+    def __init__(self, attempts: int = 3, base_delay: float = 1.0, max_delay: float = 30.0,
+                 multiplier: float = 2.0, jitter: float = 0.1):
+        if attempts < 1:
+            raise ValueError(f"attempts must be at least 1, got {attempts}")
+        self.attempts = attempts
+        self.base_delay = base_delay
+        self.max_delay = max_delay
+        self.multiplier = multiplier
+        self.jitter = jitter
+
+    def delay(self, failures: int) -> float:
+        delay = min(self.max_delay, self.base_delay * self.multiplier ** (failures - 1))
+        return delay * random.uniform(1 - self.jitter, 1 + self.jitter)
+
+    @classmethod
+    def from_dict(cls, options: Dict, default: "RetryPolicy" = None) -> "RetryPolicy":
+        default = default or cls()
+        return cls(
+            attempts=options.get("attempts", default.attempts),
+            base_delay=options.get("base_delay", default.base_delay),
+            max_delay=options.get("max_delay", default.max_delay),
+            multiplier=options.get("multiplier", default.multiplier),
+            jitter=options.get("jitter", default.jitter)
+        )
+
+
+def retry(fn: Callable[[], T], policy: RetryPolicy, retry_on: Tuple[Type[BaseException], ...] = (Exception,),
+          stop: Optional[threading.Event] = None, what: str = "call") -> T:
+    """Calls fn until it succeeds or the policy's attempts are used up.
+
+    The last error is raised if every attempt fails, or straight away if
+    `stop` is set while waiting for the next attempt.
+    """
+    for failures in range(1, policy.attempts + 1):
+        try:
+            return fn()
+        except retry_on as e:
+            if failures == policy.attempts:
+                raise
+            delay = policy.delay(failures)
+            logger.warning(f"{what} failed ({e}), retrying in {delay:.1f}s")
+            if stop is None:
+                time.sleep(delay)
+            elif stop.wait(delay):
+                raise
+
+
+async def retry_async(fn: Callable[[], Awaitable[T]], policy: RetryPolicy,
+                      retry_on: Tuple[Type[BaseException], ...] = (Exception,), what: str = "call") -> T:
+    """Like retry, for coroutines. Cancelling the caller cancels the wait too."""
+    for failures in range(1, policy.attempts + 1):
+        try:
+            return await fn()
+        except retry_on as e:
+            if failures == policy.attempts:
+                raise
+            delay = policy.delay(failures)
+            logger.warning(f"{what} failed ({e}), retrying in {delay:.1f}s")
+            await asyncio.sleep(delay)
@@ -0,0 +1,97 @@
+# rules.py
+import logging
+from typing import Dict, List, Optional, Tuple
+from actuators import Actuator
+from sensors import SensorData, is_numeric
+
+logger = logging.getLogger(__name__)
+
+
+class Rule:
+    """Switches an actuator when a sensor field crosses a threshold.
+
+    With `above`, the actuator turns on at value >= above and back off at
+    value <= above - hysteresis (e.g. a fan). With `below` it is the
+    mirror image (e.g. a heater). The actuator must also have spent at
+    least min_on_seconds / min_off_seconds in its current state before it
+    is switched again, so a noisy value can't toggle it rapidly.
+    """
+    def __init__(self, sensor: str, field: str, actuator: Actuator,
+                 above: Optional[float] = None, below: Optional[float] = None,
+                 hysteresis: float = 0, min_on_seconds: float = 0,
+                 min_off_seconds: float = 0, name: str = None):
+        if (above is None) == (below is None):
+            raise ValueError("rule needs exactly one of 'above' or 'below'")
+        self.sensor = sensor
+        self.field = field
+        self.actuator = actuator
+        self.above = above
+        self.below = below
+        self.hysteresis = hysteresis
+        self.min_on_seconds = min_on_seconds
+        self.min_off_seconds = min_off_seconds
+        self.name = name or f"{sensor}.{field} -> {actuator.name()}"
+
+    def desired(self, value: float) -> bool:
+        on = self.actuator.on
+        if self.above is not None:
+            if value >= self.above:
+                return True
+            if value <= self.above - self.hysteresis:
+                return False
+        else:
+            if value <= self.below:
+                return True
+            if value >= self.below + self.hysteresis:
+                return False
+        return on
+
+    def dwell_satisfied(self) -> bool:
+        minimum = self.min_on_seconds if self.actuator.on else self.min_off_seconds
+        return self.actuator.seconds_in_state() >= minimum
+
+    def evaluate(self, data: SensorData):
+        if data.sensor_type != self.sensor or self.field not in data.fields:
+            return
+        value = data.fields[self.field]
+        if not is_numeric(value):
+            return
+        want = self.desired(value)
+        if want == self.actuator.on or not self.dwell_satisfied():
+            return
+        self.actuator.set(want)
+        logger.info(f"Rule '{self.name}': {self.field}={value} -> "
+                    f"{self.actuator.name()} {'on' if want else 'off'}")
+
+
+class RuleEngine:
+    def __init__(self, rules: List[Rule]):
+        self.rules = rules
+
+    def evaluate(self, data: SensorData):
+        for rule in self.rules:
+            try:
+                rule.evaluate(data)
+            except Exception as e:
+                logger.error(f"Rule '{rule.name}' failed: {e}")
+
+
+def load_rules(rules_config: List[Dict], actuators: Dict[str, Actuator]) -> Tuple[List[Rule], List[str]]:
+    """The rules that load, and why each of the others didn't.
+
+    A bad rule is skipped rather than taking the rest down with it, so
+    one typo doesn't leave every actuator unattended.
+    """
+    rules, errors = [], []
+    for i, entry in enumerate(rules_config):
+        try:
+            if not isinstance(entry, dict):
+                raise ValueError(f"expected an object, got {entry!r}")
+            options = dict(entry)
+            name = options.pop("actuator", None)
+            if name not in actuators:
+                raise ValueError(f"unknown actuator {name!r}")
+            rules.append(Rule(actuator=actuators[name], **options))
+        except (TypeError, ValueError) as e:
+            errors.append(f"rule {i}: {e}")
+    return rules, errors
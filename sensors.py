@@ -1,31 +1,160 @@
 # sensors.py
 import time
+import json
 import random
+import statistics
+import struct
+import urllib.request
 from abc import ABC, abstractmethod
-from datetime import datetime
-from typing import Dict, Optional
-import adafruit_dht
+from datetime import datetime, timezone
+from enum import IntFlag
+from typing import Dict, List, Optional, Tuple, Union
 import board
 import busio
-import adafruit_bmp280
 import adafruit_bh1750
+import pins
+from clock import SYSTEM, Clock
+from retry import RetryPolicy, retry
+from simulation import Simulation
+
+# Most fields are floats; digital and event sensors can also report
+# booleans (motion), ints (counters, error codes) and strings (status).
+# Analog drivers return float() explicitly: InfluxDB fixes a field's type
+# on first write, so a whole-number reading must not arrive as an int.
+FieldValue = Union[float, int, bool, str]
+
+
+def is_numeric(value: FieldValue) -> bool:
+    # bool is an int, so on/off states count too (their mean is the fraction on)
+    return isinstance(value, (int, float))
+
+
+def field_type(value: FieldValue) -> str:
+    """The schema type of a field value: "bool", "int", "float" or "string"."""
+    if isinstance(value, bool):
+        return "bool"
+    if isinstance(value, int):
+        return "int"
+    return "float" if isinstance(value, float) else "string"
+
+
+class Quality(IntFlag):
+    """How a reading came about, set by the stage responsible; OK (0) is a fresh, clean read."""
+    OK = 0
+    # Succeeded only after failed attempts
+    RETRIED = 1
+    # Averaged over several samples (oversampling, aggregation)
+    SMOOTHED = 2
+    # A value fell outside its valid range and was clamped to it
+    CLAMPED = 4
+    # Not current, e.g. cached from before a restart
+    STALE = 8
+    # A value fell outside its valid range and was kept as read
+    OUT_OF_RANGE = 16
+    
+    def names(self) -> List[str]:
+        return [flag.name.lower() for flag in Quality if flag and flag in self]
+    
+    @classmethod
+    def from_names(cls, names: List[str]) -> "Quality":
+        # Flags a newer peer knows and we don't are left out
+        quality = cls.OK
+        for name in names:
+            quality |= cls.__members__.get(name.upper(), cls.OK)
+        return quality
+
+
+class SensorError(Exception):
+    """A read that failed for a known reason; match on the subclass, not the message."""
+
+
+class ChecksumError(SensorError):
+    """The sensor answered, but the data failed validation."""
+
+
+class ReadTimeout(SensorError, TimeoutError):
+    """The sensor didn't answer in time."""
+
+
+class InsufficientData(SensorError):
+    """The sensor answered with fewer bits or values than a full reading."""
+
+
+class DeviceNotFound(SensorError, ConnectionError):
+    """Nothing answers where the sensor should be; retrying won't help."""
+
 
 class SensorData:
-    def __init__(self, sensor_type: str, fields: Dict[str, float], timestamp: datetime = None):
+    """One reading. `timestamp` is when it was acquired, in UTC.
+    
+    It defaults to now for sensors read locally; readings from elsewhere
+    (remotes, replays) keep their original time and nothing downstream
+    overwrites it. `seq`, if set, counts readings per sensor so consumers
+    can spot dropped samples. `measurement` is set for points that aren't
+    plain readings, such as summaries, to store them apart. `quality`
+    flags readings that aren't a fresh, clean read.
+    """
+    def __init__(self, sensor_type: str, fields: Dict[str, FieldValue], timestamp: datetime = None,
+                 tags: Dict[str, str] = None, seq: Optional[int] = None, measurement: Optional[str] = None,
+                 quality: Quality = Quality.OK):
         self.sensor_type = sensor_type
         self.fields = fields
-        self.timestamp = timestamp or datetime.now()
+        self.timestamp = timestamp or datetime.now(timezone.utc)
+        self.tags = tags or {}
+        self.seq = seq
+        self.measurement = measurement
+        self.quality = quality
+    
+    def copy(self, **changes) -> "SensorData":
+        """A copy with its own fields and tags, with `changes` applied."""
+        values = {'sensor_type': self.sensor_type, 'fields': dict(self.fields), 'timestamp': self.timestamp,
+                  'tags': dict(self.tags), 'seq': self.seq, 'measurement': self.measurement,
+                  'quality': self.quality}
+        return SensorData(**{**values, **changes})
     
     def to_dict(self):
-        return {
-            'sensor_type': self.sensor_type,
-            'fields': self.fields,
-            'timestamp': self.timestamp.isoformat()
-        }
+        """The wire form; everything optional, including empty `fields` and
+        `tags`, is left out. Field values are always kept, 0 included.
+        """
+        d = {'sensor_type': self.sensor_type}
+        if self.fields:
+            d['fields'] = self.fields
+        d['timestamp'] = self.timestamp.isoformat()
+        if self.tags:
+            d['tags'] = self.tags
+        if self.seq is not None:
+            d['seq'] = self.seq
+        if self.measurement is not None:
+            d['measurement'] = self.measurement
+        if self.quality:
+            d['quality'] = self.quality.names()
+        return d
+    
+    @classmethod
+    def from_dict(cls, d: Dict):
+        timestamp = datetime.fromisoformat(d['timestamp'])
+        if timestamp.tzinfo is None:
+            # Older senders wrote naive local time
+            timestamp = timestamp.astimezone()
+        return cls(
+            sensor_type=d['sensor_type'],
+            fields=d.get('fields', {}),
+            timestamp=timestamp.astimezone(timezone.utc),
+            tags=d.get('tags'),
+            seq=d.get('seq'),
+            measurement=d.get('measurement'),
+            quality=Quality.from_names(d.get('quality', []))
+        )
 
 class Sensor(ABC):
+    # Shortest time between two reads the hardware tolerates, in seconds
+    MIN_INTERVAL = 0.0
+    # Time source for reading timestamps; replace per instance in tests
+    clock: Clock = SYSTEM
+    
     @abstractmethod
     def read(self) -> Optional[SensorData]:
+        """A reading, or None if there is none this time. May raise a SensorError."""
         pass
     
     @abstractmethod
@@ -34,95 +163,1188 @@ class Sensor(ABC):
     
     def close(self):
         pass
+    
+    def status(self) -> Dict:
+        return {}
+    
+    def metadata(self) -> Dict:
+        """Sensor type and, per field, its native unit and valid range.
+        
+        A field may also give its 'type' ("float" unless stated) and
+        'derived': True if it is computed from other fields rather than
+        measured.
+        """
+        return {'type': self.name().lower(), 'fields': {}}
+    
+    def calibrate(self, field: str, points: List[Tuple[float, float]]) -> Optional[Dict]:
+        """Calibrates `field` in the driver from two (reading, truth) points.
+        
+        Returns the settings to save, or None for a driver that leaves
+        calibration to the Calibrate wrapper, as most do.
+        """
+        return None
 
 
-class DHT22(Sensor):
-    def __init__(self, pin_name: str = "GPIO4"):
-        pin_map = {
-            "GPIO4": board.D4,
-            "GPIO17": board.D17,
-            "GPIO27": board.D27,
-            "GPIO22": board.D22,
-        }
+class SensorWrapper(Sensor):
+    """Base for decorators that add behaviour around another sensor."""
+    def __init__(self, sensor: Sensor):
+        self.sensor = sensor
+        self.clock = sensor.clock
+    
+    def name(self) -> str:
+        return self.sensor.name()
+    
+    def read(self) -> Optional[SensorData]:
+        return self.sensor.read()
+    
+    def close(self):
+        self.sensor.close()
+    
+    def status(self) -> Dict:
+        return self.sensor.status()
+    
+    def metadata(self) -> Dict:
+        return self.sensor.metadata()
+
+
+class Warmup(SensorWrapper):
+    """Discards readings until the sensor has settled after power-up.
+    
+    The sensor is considered warm once `reads` readings have been thrown
+    away and `duration` seconds have passed since construction.
+    """
+    def __init__(self, sensor: Sensor, reads: int = 0, duration: float = 0):
+        super().__init__(sensor)
+        self.reads_left = reads
+        self.ready_at = self.clock.monotonic() + duration
+    
+    @property
+    def warming_up(self) -> bool:
+        return self.reads_left > 0 or self.clock.monotonic() < self.ready_at
+    
+    def read(self) -> Optional[SensorData]:
+        result = self.sensor.read()
+        if not self.warming_up:
+            return result
+        if result:
+            self.reads_left = max(self.reads_left - 1, 0)
+        return None
+    
+    def status(self) -> Dict:
+        return {**self.sensor.status(), 'warming_up': self.warming_up}
+
+
+def reject_outliers(values: List[float], threshold: float) -> List[float]:
+    """`values` without those more than `threshold` median absolute deviations from the median.
+    
+    The median and its deviation aren't pulled along by the outliers
+    themselves, unlike the mean and standard deviation.
+    """
+    if len(values) < 3:
+        return values
+    median = statistics.median(values)
+    deviation = statistics.median(abs(value - median) for value in values)
+    if deviation == 0:
+        # Most samples agree exactly; anything else is the odd one out
+        return [value for value in values if value == median]
+    return [value for value in values if abs(value - median) <= threshold * deviation]
+
+
+class Oversample(SensorWrapper):
+    """Averages `samples` back-to-back physical readings into one.
+    
+    Samples are at least `spacing` seconds apart, and never closer than
+    the driver's MIN_INTERVAL (2s for the DHT22), so a reading takes
+    `span` seconds longer than one sample. Per numeric field, samples
+    beyond `outlier` median absolute deviations are dropped before
+    averaging, and int fields are rounded to stay ints; other fields take
+    the last sample's value. A failed
+    sample is skipped; the error is only raised if every sample fails.
+    """
+    def __init__(self, sensor: Sensor, samples: int, spacing: float = 0, outlier: float = 3.0):
+        super().__init__(sensor)
+        if samples < 1:
+            raise ValueError(f"{self.name()}: oversample needs at least 1 sample, got {samples}")
+        self.samples = samples
+        self.spacing = max(spacing, unwrap(sensor).MIN_INTERVAL)
+        self.outlier = outlier
+    
+    @property
+    def span(self) -> float:
+        return (self.samples - 1) * self.spacing
+    
+    def _collect(self) -> List[SensorData]:
+        results = []
+        error = None
+        for i in range(self.samples):
+            if i:
+                self.clock.sleep(max(0.0, started + self.spacing - self.clock.monotonic()))
+            started = self.clock.monotonic()
+            try:
+                result = self.sensor.read()
+            except SensorError as e:
+                error = e
+                continue
+            if result:
+                results.append(result)
+        if not results and error:
+            raise error
+        return results
+    
+    def read(self) -> Optional[SensorData]:
+        results = self._collect()
+        if not results:
+            return None
+        last = results[-1]
+        fields = dict(last.fields)
+        for key, value in last.fields.items():
+            if not is_numeric(value) or isinstance(value, bool):
+                continue
+            values = reject_outliers([r.fields[key] for r in results if is_numeric(r.fields.get(key))],
+                                     self.outlier)
+            mean = sum(values) / len(values)
+            # InfluxDB fixes a field's type on first write, so an int field stays an int
+            fields[key] = round(mean) if isinstance(value, int) else mean
+        quality = Quality.OK
+        for result in results:
+            quality |= result.quality
+        if len(results) > 1:
+            quality |= Quality.SMOOTHED
+        # Stamped halfway through, where the average sits
+        middle = results[0].timestamp + (last.timestamp - results[0].timestamp) / 2
+        return last.copy(fields=fields, timestamp=middle, quality=quality)
+    
+    def status(self) -> Dict:
+        return {**self.sensor.status(), 'oversample': self.samples}
+
+
+class Rename(SensorWrapper):
+    """Renames fields, e.g. {"temperature": "air_temp"}; others keep their names.
+    
+    A rename that would give two fields of one reading the same name is
+    an error, caught at construction for the fields the sensor declares.
+    """
+    def __init__(self, sensor: Sensor, names: Dict[str, str]):
+        super().__init__(sensor)
+        self.names = names
+        self._renamed(sensor.metadata()['fields'])
+    
+    def _renamed(self, fields: Dict) -> Dict:
+        renamed = {}
+        for key, value in fields.items():
+            name = self.names.get(key, key)
+            if name in renamed:
+                raise ValueError(f"{self.name()}: renaming fields gives two fields called {name!r}")
+            renamed[name] = value
+        return renamed
+    
+    def read(self) -> Optional[SensorData]:
+        result = self.sensor.read()
+        if result:
+            result.fields = self._renamed(result.fields)
+        return result
+    
+    def metadata(self) -> Dict:
+        metadata = self.sensor.metadata()
+        return {**metadata, 'fields': self._renamed(metadata['fields'])}
+
+
+class Calibrate(SensorWrapper):
+    """Applies a linear correction (value * scale + offset) per field.
+    
+    Fields without an entry in `calibration` pass through untouched.
+    """
+    def __init__(self, sensor: Sensor, calibration: Dict[str, Dict[str, float]]):
+        super().__init__(sensor)
+        self.calibration = calibration
+    
+    def read(self) -> Optional[SensorData]:
+        result = self.sensor.read()
+        if not result:
+            return result
+        for key, value in result.fields.items():
+            if key in self.calibration and is_numeric(value):
+                coeffs = self.calibration[key]
+                result.fields[key] = value * coeffs.get("scale", 1.0) + coeffs.get("offset", 0.0)
+        return result
+
+
+def two_point_calibration(read1: float, true1: float, read2: float, true2: float) -> Dict[str, float]:
+    """Scale and offset mapping two readings onto their reference values."""
+    if read1 == read2:
+        raise ValueError("the two readings must differ")
+    scale = (true2 - true1) / (read2 - read1)
+    return {"scale": scale, "offset": true1 - scale * read1}
+
+
+class Deduplicate(SensorWrapper):
+    """Drops fields that haven't changed since they were last reported.
+    
+    `epsilons` maps field name to the minimum change worth reporting;
+    fields not listed are always reported. A field is still reported
+    every `heartbeat` seconds so a steady value doesn't look like an
+    outage. Returns None when every field was suppressed.
+    """
+    def __init__(self, sensor: Sensor, epsilons: Dict[str, float], heartbeat: float = 300):
+        super().__init__(sensor)
+        self.epsilons = epsilons
+        self.heartbeat = heartbeat
+        self.last_values: Dict[str, FieldValue] = {}
+        self.last_sent: Dict[str, float] = {}
+    
+    def read(self) -> Optional[SensorData]:
+        result = self.sensor.read()
+        if not result:
+            return result
         
-        pin = pin_map.get(pin_name, board.D4)
-        self.dht_device = adafruit_dht.DHT22(pin, use_pulseio=False)
-        self.pin_name = pin_name
+        now = self.clock.monotonic()
+        fields = {}
+        for key, value in result.fields.items():
+            if key in self.epsilons and key in self.last_values:
+                last = self.last_values[key]
+                if is_numeric(value) and is_numeric(last):
+                    changed = abs(value - last) > self.epsilons[key]
+                else:
+                    changed = value != last
+                stale = now - self.last_sent[key] >= self.heartbeat
+                if not changed and not stale:
+                    continue
+            fields[key] = value
+            self.last_values[key] = value
+            self.last_sent[key] = now
+        
+        if not fields:
+            return None
+        result.fields = fields
+        return result
+
+
+class PulseReplay:
+    """Recorded DHT22 signals played back in place of the pin.
+    
+    Each frame is the pulse widths in microseconds, as DHT22.capture_pulses
+    produces them and `GET /api/debug/dht22` shows them; every read takes
+    the next frame, wrapping around. The driver's own parsing and checksum
+    run on them unchanged, so a capture of a failing sensor can be replayed
+    without the hardware.
+    """
+    # The line's high after the host lets go, and the sensor's response low and high
+    RELEASE_US, RESPONSE_US = 30, 80
+    # Low before each bit, and the high that encodes it
+    LOW_US, ZERO_US, ONE_US = 50, 26, 70
+    
+    def __init__(self, frames: List[List[int]]):
+        if not frames:
+            raise ValueError("a replay needs at least one frame")
+        self.frames = frames
+        self.next = 0
+    
+    @classmethod
+    def from_file(cls, path: str) -> "PulseReplay":
+        # One capture or a list of them, each {"pulses_us": [...]} or the bare list
+        with open(path) as f:
+            captures = json.load(f)
+        if isinstance(captures, dict) or (captures and isinstance(captures[0], int)):
+            captures = [captures]
+        return cls([c["pulses_us"] if isinstance(c, dict) else c for c in captures])
+    
+    @classmethod
+    def frame(cls, humidity: float, temperature: float, corrupt: bool = False) -> List[int]:
+        """The pulses of a clean read of these values; `corrupt` flips a bit so the checksum fails."""
+        t = round(abs(temperature) * 10) | (0x8000 if temperature < 0 else 0)
+        h = round(humidity * 10)
+        data = [h >> 8, h & 0xFF, t >> 8, t & 0xFF]
+        data.append(sum(data) & 0xFF)
+        bits = [(byte >> (7 - i)) & 1 for byte in data for i in range(8)]
+        if corrupt:
+            bits[15] ^= 1
+        pulses = [cls.RELEASE_US, cls.RESPONSE_US, cls.RESPONSE_US]
+        for bit in bits:
+            pulses += [cls.LOW_US, cls.ONE_US if bit else cls.ZERO_US]
+        return pulses + [cls.LOW_US]
+    
+    def __call__(self) -> List[int]:
+        pulses = self.frames[self.next % len(self.frames)]
+        self.next += 1
+        return list(pulses)
+
+
+class DHT22(Sensor):
+    """An AM2302/DHT22 on one GPIO, bit-banged through digitalio.
+    
+    A read drives the line high for `start_high_ms` so it settles, low for
+    `start_low_ms`, then releases it to the pull-up and times every edge
+    for `response_timeout_ms`. `pull` "up" adds the Pi's own ~50k pull-up
+    to the one on the module; "off" relies on the module's or an external
+    4.7-10k alone, which keeps the edges sharper on long cables. The sensor answers
+    with a ~80us low and ~80us high, then 40 bits, each a ~50us low followed
+    by a ~26us (0) or ~70us (1) high; a high longer than `bit_threshold_us` is a 1.
+    """
+    # The datasheet allows one reading every 2 seconds
+    MIN_INTERVAL = 2.0
+    # The sensor's response low and high are longer than this, a bit's low well under it
+    RESPONSE_US = 65
+    # The low before each of the 40 bits and the high that encodes it
+    DATA_PULSES = 80
+    # Fewer edges than this means nothing answered at all
+    MIN_PULSES = 10
+    PULLS = ("up", "off")
+    
+    def __init__(self, pin_name: pins.PinId = "GPIO4", debug: bool = False, start_low_ms: float = 1.0,
+                 start_high_ms: float = 100, pull: str = "up", bit_threshold_us: int = 51,
+                 response_timeout_ms: float = 250, retry: RetryPolicy = None, simulation: Simulation = None,
+                 replay: PulseReplay = None):
+        # Checked even when simulated, so a typo shows up before the hardware does
+        self.pin_name = pins.name(pin_name)
+        self.retry = retry or RetryPolicy(attempts=1)
+        self.simulation = simulation
+        self.replay = replay
+        self.debug = debug
+        self.last_pulses = None
+        self.last_capture = None
+        # The AM2302 datasheet allows a 0.8-20ms start pulse (typically 1ms);
+        # some clones only answer reliably to a longer one, e.g. 18ms.
+        if not 0.8 <= start_low_ms <= 20:
+            raise ValueError(f"start_low_ms must be between 0.8 and 20, got {start_low_ms}")
+        self.start_low_ms = start_low_ms
+        # Not in the datasheet; the line only has to be high and steady before the start pulse
+        if not 1 <= start_high_ms <= 1000:
+            raise ValueError(f"start_high_ms must be between 1 and 1000, got {start_high_ms}")
+        self.start_high_ms = start_high_ms
+        if pull not in self.PULLS:
+            raise ValueError(f"pull must be one of {self.PULLS}, got {pull!r}")
+        self.pull = pull
+        # Long cables round off the edges, stretching 0s, so it may need to move up
+        if not 28 < bit_threshold_us < 70:
+            raise ValueError(f"bit_threshold_us must be between 28 and 70, got {bit_threshold_us}")
+        self.bit_threshold_us = bit_threshold_us
+        # The answer itself takes about 5ms after the start pulse
+        if not 10 <= response_timeout_ms <= 1000:
+            raise ValueError(f"response_timeout_ms must be between 10 and 1000, got {response_timeout_ms}")
+        self.response_timeout_ms = response_timeout_ms
+        self.pin = None
+        if simulation is None and replay is None:
+            # The data line idles high on a pull-up and is driven low to start a read
+            self.pin = pins.resolve(self.pin_name, input_pull_up=pull == "up", output=True)
+    
+    def capture_pulses(self) -> List[int]:
+        """The widths in microseconds of the line's levels after one start
+        signal, from when it is released. A replay stands in for the pin.
+        """
+        if self.replay is not None:
+            return self.replay()
+        import digitalio
+        pulses = []
+        with digitalio.DigitalInOut(self.pin) as line:
+            line.switch_to_output(value=True)
+            time.sleep(self.start_high_ms / 1000)
+            line.value = False
+            time.sleep(self.start_low_ms / 1000)
+            line.switch_to_input(pull=digitalio.Pull.UP if self.pull == "up" else None)
+            level = True
+            transition = time.monotonic_ns()
+            deadline = transition + int(self.response_timeout_ms * 1_000_000)
+            while time.monotonic_ns() < deadline:
+                if line.value != level:
+                    now = time.monotonic_ns()
+                    level = not level
+                    pulses.append(min((now - transition) // 1000, 65535))
+                    transition = now
+        return pulses
+
+    def _data_start(self, pulses: List[int]) -> Optional[int]:
+        """Where the data bits start: at the first short pulse after the
+        response's long low and high, None if there is no response.
+        """
+        # A long release high before the response only lengthens the run of long pulses
+        for i in range(2, len(pulses)):
+            if min(pulses[i - 2], pulses[i - 1]) > self.RESPONSE_US >= pulses[i]:
+                return i
+        return None
+    
+    def decode(self, pulses: List[int]) -> Tuple[float, float]:
+        """Humidity (%) and temperature (°C) from captured pulses. Raises SensorError."""
+        if len(pulses) < self.MIN_PULSES:
+            raise DeviceNotFound(f"no DHT22 answering on {self.pin_name}, check the wiring "
+                                 f"({len(pulses)} pulses)")
+        # Counting from the response, so neither a missing closing low nor
+        # a long high before the response shifts the bits
+        start = self._data_start(pulses)
+        if start is None:
+            raise InsufficientData(f"no DHT22 response pulse in {len(pulses)} pulses")
+        pulses = pulses[start:start + self.DATA_PULSES]
+        if len(pulses) < self.DATA_PULSES:
+            raise InsufficientData(f"DHT22 answered with {len(pulses)} of {self.DATA_PULSES} data pulses")
+        # Even indexes are the lows before each bit, odd ones the highs that encode it
+        bits = [int(width > self.bit_threshold_us) for width in pulses[1::2]]
+        data = [sum(bit << (7 - i) for i, bit in enumerate(bits[start:start + 8])) for start in range(0, 40, 8)]
+        if sum(data[:4]) & 0xFF != data[4]:
+            raise ChecksumError(f"DHT22 checksum mismatch: {data[4]:#04x}, expected {sum(data[:4]) & 0xFF:#04x}")
+        humidity = ((data[0] << 8) | data[1]) / 10
+        temperature = (((data[2] & 0x7F) << 8) | data[3]) / 10
+        if data[2] & 0x80:
+            temperature = -temperature
+        # A valid checksum over misread bits can still happen
+        if not (0 <= humidity <= 100 and -40 <= temperature <= 80):
+            raise ChecksumError(f"DHT22 returned implausible data: {humidity}%, {temperature}°C")
+        return humidity, temperature
+    
+    # Failures worth another try; a sensor that isn't there won't appear on retry
+    RETRYABLE = (ChecksumError, ReadTimeout, InsufficientData)
     
     def name(self) -> str:
         return "DHT22"
     
+    def _measure(self) -> SensorData:
+        if self.simulation is not None:
+            return SensorData(sensor_type="dht22", timestamp=self.clock.now(),
+                              fields=self.simulation.sample(self.clock.now()))
+        pulses = self.capture_pulses()
+        if self.debug:
+            # Kept before decoding, so a failed read can be looked at too
+            self.last_pulses = list(pulses)
+            self.last_capture = self.clock.now()
+        humidity, temperature = self.decode(pulses)
+        return SensorData(
+            sensor_type="dht22",
+            timestamp=self.clock.now(),
+            fields={
+                "temperature": temperature,
+                "humidity": humidity
+            }
+        )
+    
     def read(self) -> Optional[SensorData]:
+        attempts = 0
+        
+        def measure():
+            nonlocal attempts
+            attempts += 1
+            return self._measure()
+        
+        result = retry(measure, self.retry, retry_on=self.RETRYABLE, what="DHT22 read")
+        if attempts > 1:
+            result.quality |= Quality.RETRIED
+        return result
+    
+    def metadata(self) -> Dict:
+        return {
+            'type': 'dht22',
+            'fields': {
+                'temperature': {'unit': '°C', 'min': -40, 'max': 80},
+                'humidity': {'unit': '%', 'min': 0, 'max': 100}
+            }
+        }
+
+# Value of the chip ID register (0xD0) on the two pin-compatible Bosch parts
+CHIP_IDS = {0x58: "BMP280", 0x60: "BME280"}
+
+
+# board.SCL/board.SDA on a Raspberry Pi
+I2C_BUS = "/dev/i2c-1"
+# Failed reads in a row before an I2C sensor's bus handle is reopened
+I2C_REOPEN_AFTER = 3
+
+
+def _probe(i2c, address: int, register: Optional[int] = None) -> Optional[int]:
+    """Checks a device answers at `address`, reading `register` if given.
+    
+    Fails fast with the address and bus instead of letting the driver
+    return zeros or hang when nothing is wired up.
+    """
+    from adafruit_bus_device.i2c_device import I2CDevice
+    try:
+        # I2CDevice itself probes the address and raises if there's no ACK
+        device = I2CDevice(i2c, address)
+        if register is None:
+            return None
+        result = bytearray(1)
+        with device:
+            device.write_then_readinto(bytes([register]), result)
+        return result[0]
+    except (OSError, ValueError) as e:
+        raise DeviceNotFound(f"no ACK from device at {address:#x} on {I2C_BUS}, "
+                              f"check the wiring and address ({e})") from e
+
+
+def _chip_id(i2c, address: int) -> int:
+    return _probe(i2c, address, 0xD0)
+
+
+# Sensor types that are usually found at each address
+KNOWN_ADDRESSES = {
+    0x23: ["gy32"], 0x5C: ["gy32"],
+    0x48: ["ads1115"], 0x49: ["ads1115"], 0x4A: ["ads1115"], 0x4B: ["ads1115"],
+    0x76: ["bmp280", "bme280"], 0x77: ["bmp280", "bme280"],
+}
+
+
+def scan_i2c(lock_timeout: float = 1.0) -> List[Dict]:
+    """Addresses answering on the I2C bus, with the sensor types to try.
+    
+    BMP280 and BME280 share addresses, so for those the chip ID decides.
+    Raises if the bus isn't available or can't be scanned.
+    """
+    i2c = busio.I2C(board.SCL, board.SDA)
+    try:
+        deadline = time.monotonic() + lock_timeout
+        while not i2c.try_lock():
+            if time.monotonic() > deadline:
+                raise TimeoutError(f"{I2C_BUS} is busy")
+            time.sleep(0.01)
         try:
-            temperature = self.dht_device.temperature
-            humidity = self.dht_device.humidity
-            
-            if temperature is not None and humidity is not None:
-                return SensorData(
-                    sensor_type="dht22",
-                    fields={
-                        "temperature": temperature,
-                        "humidity": humidity
-                    }
-                )
-        except RuntimeError as e:
-            print(f"DHT22 read error: {e}")
-        return None
+            addresses = i2c.scan()
+        finally:
+            i2c.unlock()
+        
+        devices = []
+        for address in addresses:
+            device = {"address": f"{address:#04x}", "sensor_types": KNOWN_ADDRESSES.get(address, [])}
+            if address in (0x76, 0x77):
+                try:
+                    chip_id = _chip_id(i2c, address)
+                    device["chip_id"] = f"{chip_id:#04x}"
+                    if chip_id in CHIP_IDS:
+                        device["sensor_types"] = [CHIP_IDS[chip_id].lower()]
+                except ConnectionError:
+                    pass
+            devices.append(device)
+        return devices
+    finally:
+        i2c.deinit()
+
+
+def _check_chip(i2c, address: int, expected: int):
+    # Many boards sold as "BMP280" carry a BME280, and the other way round
+    chip_id = _chip_id(i2c, address)
+    if chip_id == expected:
+        return
+    if chip_id in CHIP_IDS:
+        found = CHIP_IDS[chip_id]
+        raise ValueError(f"device at {address:#x} is a {found} (chip ID {chip_id:#x}), "
+                         f"not a {CHIP_IDS[expected]}; configure it as {found.lower()}")
+    raise ValueError(f"device at {address:#x} has chip ID {chip_id:#x}, "
+                     f"expected {expected:#x} for a {CHIP_IDS[expected]}")
+
+
+class I2CSensor(Sensor):
+    """A sensor on the I2C bus that reopens its handle when the bus wedges.
     
-    def close(self):
-        self.dht_device.exit()
+    After `reopen_after` failed reads in a row (0: never) the bus and the
+    device are closed and opened again before the next read, which often
+    clears the Pi's I2C lockups without restarting the server. Subclasses
+    open their device in `_open` and report each read to `_succeeded` or
+    `_failed`.
+    """
+    def __init__(self, address: int, reopen_after: int = 0):
+        self.address = address
+        self.reopen_after = reopen_after
+        self.i2c = None
+        self.failures = 0
+        self.reopens = 0
+        self.last_reopen = None
+    
+    def _open(self, i2c):
+        raise NotImplementedError
+    
+    def _connect(self):
+        i2c = busio.I2C(board.SCL, board.SDA)
+        try:
+            self._open(i2c)
+        except Exception:
+            i2c.deinit()
+            raise
+        self.i2c = i2c
+    
+    def _succeeded(self):
+        self.failures = 0
+    
+    def _failed(self):
+        self.failures += 1
+        if self.reopen_after and self.failures >= self.reopen_after:
+            self._reopen()
+    
+    def _reopen(self):
+        print(f"{self.name()}: {self.failures} failed reads in a row, reopening the I2C bus")
+        self.failures = 0
+        self.reopens += 1
+        self.last_reopen = {"at": self.clock.now().isoformat(), "error": None}
+        if self.i2c is not None:
+            try:
+                self.i2c.deinit()
+            except Exception:
+                pass
+            self.i2c = None
+        try:
+            self._connect()
+        except Exception as e:
+            # Tried again after another reopen_after failures
+            print(f"{self.name()}: reopening the I2C bus failed: {e}")
+            self.last_reopen["error"] = str(e)
+    
+    def status(self) -> Dict:
+        if self.i2c is None and not self.reopens:
+            # Simulated
+            return {}
+        return {'i2c_failures': self.failures, 'i2c_reopens': self.reopens, 'last_i2c_reopen': self.last_reopen}
+
+
+def altitude(pressure: float, sea_level: float = 1013.25) -> float:
+    # The international barometric formula, as adafruit_bme280 computes it
+    return 44330 * (1.0 - (pressure / sea_level) ** 0.1903)
 
-class BMP280(Sensor):
-    def __init__(self, address: int = 0x76):
+
+# dig_T1..T3 and dig_P1..P9 from 0x88, little-endian; only T1 and P1 are unsigned
+BMP280_CALIBRATION = struct.Struct("<HhhHhhhhhhhh")
+BMP280_CALIBRATION_NAMES = ("T1", "T2", "T3", "P1", "P2", "P3", "P4", "P5", "P6", "P7", "P8", "P9")
+# What the data registers hold until the first conversion, or for a skipped measurement
+BMP280_SKIPPED = 0x80000
+
+
+def bmp280_calibration(block: bytes) -> Dict[str, int]:
+    """The calibration words in the 24 bytes read from 0x88."""
+    return dict(zip(BMP280_CALIBRATION_NAMES, BMP280_CALIBRATION.unpack(block)))
+
+
+def _c_div(a: int, b: int) -> int:
+    # C's integer division truncates towards zero; Python's // floors
+    quotient = abs(a) // abs(b)
+    return quotient if (a < 0) == (b < 0) else -quotient
+
+
+def bmp280_temperature(adc_t: int, cal: Dict[str, int]) -> Tuple[int, float]:
+    """t_fine and the temperature in °C, by the datasheet's 32-bit integer formula.
+    
+    Python's ints don't overflow and >> is arithmetic, as the datasheet
+    assumes, so with the words unpacked signed this is the reference code
+    exactly, including below freezing where t_fine goes negative.
+    """
+    var1 = (((adc_t >> 3) - (cal["T1"] << 1)) * cal["T2"]) >> 11
+    var2 = (((((adc_t >> 4) - cal["T1"]) * ((adc_t >> 4) - cal["T1"])) >> 12) * cal["T3"]) >> 14
+    t_fine = var1 + var2
+    return t_fine, ((t_fine * 5 + 128) >> 8) / 100
+
+
+def bmp280_pressure(adc_p: int, t_fine: int, cal: Dict[str, int]) -> float:
+    """The pressure in hPa, by the datasheet's 64-bit integer formula."""
+    var1 = t_fine - 128000
+    var2 = var1 * var1 * cal["P6"]
+    var2 = var2 + ((var1 * cal["P5"]) << 17)
+    var2 = var2 + (cal["P4"] << 35)
+    var1 = ((var1 * var1 * cal["P3"]) >> 8) + ((var1 * cal["P2"]) << 12)
+    var1 = (((1 << 47) + var1) * cal["P1"]) >> 33
+    if var1 == 0:
+        raise ChecksumError("BMP280 calibration has dig_P1 = 0")
+    p = 1048576 - adc_p
+    p = _c_div(((p << 31) - var2) * 3125, var1)
+    var1 = (cal["P9"] * (p >> 13) * (p >> 13)) >> 25
+    var2 = (cal["P8"] * p) >> 19
+    p = ((p + var1 + var2) >> 8) + (cal["P7"] << 4)
+    # Pa in Q24.8
+    return p / 256 / 100
+
+
+class BMP280(I2CSensor):
+    """Temperature and pressure from a Bosch BMP280, compensated here.
+    
+    The calibration words are read once when the device is opened and the
+    compensation is the datasheet's integer code (bmp280_temperature,
+    bmp280_pressure), with the signedness of every word as specified.
+    Runs in normal mode at x2 temperature and x16 pressure oversampling.
+    """
+    # Normal mode, osrs_t x2, osrs_p x16; standby 0.5ms, IIR filter off
+    CTRL_MEAS = 0b010_101_11
+    CONFIG = 0x00
+    
+    def __init__(self, address: int = 0x76, simulation: Simulation = None, reopen_after: int = 0):
+        super().__init__(address, reopen_after)
+        self.simulation = simulation
+        self.device = None
+        self.calibration: Dict[str, int] = {}
+        if simulation is not None:
+            return
         try:
-            i2c = busio.I2C(board.SCL, board.SDA)
-            self.bmp280 = adafruit_bmp280.Adafruit_BMP280_I2C(i2c, address=address)
-            self.bmp280.sea_level_pressure = 1013.25
+            self._connect()
         except Exception as e:
             print(f"BMP280 initialization failed: {e}")
-            self.bmp280 = None
+            raise
+    
+    def _open(self, i2c):
+        from adafruit_bus_device.i2c_device import I2CDevice
+        _check_chip(i2c, self.address, 0x58)
+        self.device = I2CDevice(i2c, self.address)
+        self.calibration = bmp280_calibration(self._read_registers(0x88, BMP280_CALIBRATION.size))
+        with self.device:
+            self.device.write(bytes([0xF5, self.CONFIG]))
+            self.device.write(bytes([0xF4, self.CTRL_MEAS]))
+    
+    def _read_registers(self, register: int, length: int) -> bytes:
+        result = bytearray(length)
+        with self.device:
+            self.device.write_then_readinto(bytes([register]), result)
+        return bytes(result)
     
     def name(self) -> str:
         return "BMP280"
     
+    def compensate(self, data: bytes) -> Dict[str, float]:
+        """Fields from the 6 bytes of press_msb..temp_xlsb at 0xF7."""
+        adc_p = (data[0] << 12) | (data[1] << 4) | (data[2] >> 4)
+        adc_t = (data[3] << 12) | (data[4] << 4) | (data[5] >> 4)
+        if adc_t == BMP280_SKIPPED or adc_p == BMP280_SKIPPED:
+            raise InsufficientData("BMP280 hasn't finished a measurement yet")
+        t_fine, temperature = bmp280_temperature(adc_t, self.calibration)
+        pressure = bmp280_pressure(adc_p, t_fine, self.calibration)
+        return {"temperature": temperature, "pressure": pressure, "altitude": altitude(pressure)}
+    
     def read(self) -> Optional[SensorData]:
-        if not self.bmp280:
-            return None
         try:
+            if self.simulation is not None:
+                fields = self.simulation.sample(self.clock.now())
+                fields["altitude"] = altitude(fields["pressure"])
+            else:
+                fields = self.compensate(self._read_registers(0xF7, 6))
+            self._succeeded()
             return SensorData(
                 sensor_type="bmp280",
-                fields={
-                    "temperature": self.bmp280.temperature,
-                    "pressure": self.bmp280.pressure,
-                    "altitude": self.bmp280.altitude
-                }
+                timestamp=self.clock.now(),
+                fields=fields
             )
         except Exception as e:
             print(f"BMP280 read error: {e}")
+            self._failed()
             return None
+    
+    def metadata(self) -> Dict:
+        return {
+            'type': 'bmp280',
+            'fields': {
+                'temperature': {'unit': '°C', 'min': -40, 'max': 85},
+                'pressure': {'unit': 'hPa', 'min': 300, 'max': 1100},
+                'altitude': {'unit': 'm', 'min': -500, 'max': 9000, 'derived': True}
+            }
+        }
 
-class GY32(Sensor):
-    def __init__(self, address: int = 0x23):
+class BME280(I2CSensor):
+    """Temperature, pressure and humidity from a Bosch BME280.
+    
+    Register-compatible with the BMP280 plus a humidity channel with its
+    own calibration words (dig_H1..H6); adafruit_bme280 reads those and
+    applies the datasheet compensation.
+    """
+    def __init__(self, address: int = 0x76, simulation: Simulation = None, reopen_after: int = 0):
+        super().__init__(address, reopen_after)
+        self.simulation = simulation
+        if simulation is not None:
+            return
         try:
-            i2c = busio.I2C(board.SCL, board.SDA)
-            self.bh1750 = adafruit_bh1750.BH1750(i2c, address=address)
+            self._connect()
+        except Exception as e:
+            print(f"BME280 initialization failed: {e}")
+            raise
+    
+    def _open(self, i2c):
+        from adafruit_bme280 import basic as adafruit_bme280
+        _check_chip(i2c, self.address, 0x60)
+        self.bme280 = adafruit_bme280.Adafruit_BME280_I2C(i2c, address=self.address)
+        self.bme280.sea_level_pressure = 1013.25
+    
+    def name(self) -> str:
+        return "BME280"
+    
+    def read(self) -> Optional[SensorData]:
+        try:
+            if self.simulation is not None:
+                fields = self.simulation.sample(self.clock.now())
+                fields["altitude"] = altitude(fields["pressure"])
+            else:
+                fields = {
+                    "temperature": float(self.bme280.temperature),
+                    "pressure": float(self.bme280.pressure),
+                    "humidity": float(self.bme280.relative_humidity),
+                    "altitude": float(self.bme280.altitude)
+                }
+            self._succeeded()
+            return SensorData(
+                sensor_type="bme280",
+                timestamp=self.clock.now(),
+                fields=fields
+            )
+        except Exception as e:
+            print(f"BME280 read error: {e}")
+            self._failed()
+            return None
+    
+    def metadata(self) -> Dict:
+        return {
+            'type': 'bme280',
+            'fields': {
+                'temperature': {'unit': '°C', 'min': -40, 'max': 85},
+                'pressure': {'unit': 'hPa', 'min': 300, 'max': 1100},
+                'humidity': {'unit': '%', 'min': 0, 'max': 100},
+                'altitude': {'unit': 'm', 'min': -500, 'max': 9000, 'derived': True}
+            }
+        }
+
+class GY32(I2CSensor):
+    def __init__(self, address: int = 0x23, simulation: Simulation = None, reopen_after: int = 0):
+        super().__init__(address, reopen_after)
+        self.simulation = simulation
+        if simulation is not None:
+            return
+        try:
+            self._connect()
         except Exception as e:
             print(f"GY32 initialization failed: {e}")
-            self.bh1750 = None
+            raise
+    
+    def _open(self, i2c):
+        _probe(i2c, self.address)
+        self.bh1750 = adafruit_bh1750.BH1750(i2c, address=self.address)
     
     def name(self) -> str:
         return "GY32"
     
     def read(self) -> Optional[SensorData]:
-        if not self.bh1750:
-            return None
         try:
+            if self.simulation is not None:
+                fields = self.simulation.sample(self.clock.now())
+            else:
+                fields = {
+                    "lux": float(self.bh1750.lux)
+                }
+            self._succeeded()
             return SensorData(
                 sensor_type="gy32",
+                timestamp=self.clock.now(),
+                fields=fields
+            )
+        except Exception as e:
+            print(f"GY32 read error: {e}")
+            self._failed()
+            return None
+    
+    def metadata(self) -> Dict:
+        return {
+            'type': 'gy32',
+            'fields': {
+                'lux': {'unit': 'lux', 'min': 0, 'max': 65535}
+            }
+        }
+
+
+class RemoteSensor(Sensor):
+    """Re-emits one sensor's readings from another IoTGo instance.
+    
+    Polls the remote's /api/sensors/latest and returns the reading for
+    `sensor_type`, keeping its tags and timestamp. A reading is only
+    returned once; None means the remote has nothing new.
+    """
+    def __init__(self, url: str, sensor_type: str, device: str = None, token: str = None,
+                 timeout: float = 5):
+        self.url = url.rstrip('/') + '/api/sensors/latest'
+        self.sensor_type = sensor_type
+        self.device = device
+        self.token = token
+        self.timeout = timeout
+        self.last_timestamp = None
+        self.last_error = None
+    
+    def name(self) -> str:
+        return f"remote:{self.device or self.url}:{self.sensor_type}"
+    
+    def read(self) -> Optional[SensorData]:
+        request = urllib.request.Request(self.url)
+        if self.token:
+            request.add_header('Authorization', f'Bearer {self.token}')
+        try:
+            with urllib.request.urlopen(request, timeout=self.timeout) as resp:
+                readings = json.load(resp)
+        except Exception as e:
+            self.last_error = str(e)
+            raise ConnectionError(f"remote {self.url} unavailable: {e}") from e
+        
+        self.last_error = None
+        for reading in readings:
+            # A reading the remote only has from its cache was already relayed before it restarted
+            if reading.get('sensor_type') != self.sensor_type or reading.get('stale'):
+                continue
+            data = SensorData.from_dict(reading)
+            if data.timestamp == self.last_timestamp:
+                return None
+            self.last_timestamp = data.timestamp
+            if self.device:
+                data.tags.setdefault('device', self.device)
+            return data
+        return None
+    
+    def status(self) -> Dict:
+        return {'remote': self.url, 'last_error': self.last_error}
+    
+    def metadata(self) -> Dict:
+        return {'type': self.sensor_type, 'fields': {}}
+
+
+class ADS1115(I2CSensor):
+    """Voltage on one channel of an ADS1115 ADC, e.g. a battery.
+    
+    `divider` is the ratio of the external voltage divider, so the
+    reported voltage is the voltage before the divider.
+    """
+    def __init__(self, address: int = 0x48, channel: int = 0, divider: float = 1.0,
+                 simulated: bool = False, reopen_after: int = 0):
+        super().__init__(address, reopen_after)
+        self.divider = divider
+        self.channel_number = channel
+        self.channel = None
+        if simulated:
+            return
+        try:
+            self._connect()
+        except Exception as e:
+            print(f"ADS1115 initialization failed: {e}")
+            raise
+    
+    def _open(self, i2c):
+        import adafruit_ads1x15.ads1115 as ADS
+        from adafruit_ads1x15.analog_in import AnalogIn
+        _probe(i2c, self.address)
+        ads = ADS.ADS1115(i2c, address=self.address)
+        self.channel = AnalogIn(ads, self.channel_number)
+    
+    def name(self) -> str:
+        return "ADS1115"
+    
+    def scaled_voltage(self, measured: float) -> float:
+        return measured * self.divider
+    
+    def read(self) -> Optional[SensorData]:
+        try:
+            if self.channel is None:
+                # Simulated: a 3.7V cell seen through the divider
+                measured = random.gauss(3.7, 0.01) / self.divider
+            else:
+                measured = self.channel.voltage
+            self._succeeded()
+            return SensorData(
+                sensor_type="ads1115",
+                timestamp=self.clock.now(),
                 fields={
-                    "lux": self.bh1750.lux
+                    "voltage": self.scaled_voltage(measured)
                 }
             )
         except Exception as e:
-            print(f"GY32 read error: {e}")
-            return None
\ No newline at end of file
+            print(f"ADS1115 read error: {e}")
+            self._failed()
+            return None
+    
+    def metadata(self) -> Dict:
+        # Full scale at the default gain is 4.096V at the ADC input
+        return {
+            'type': 'ads1115',
+            'fields': {
+                'voltage': {'unit': 'V', 'min': 0, 'max': self.scaled_voltage(4.096)}
+            }
+        }
+
+
+class MCP3008(Sensor):
+    """One channel of an MCP3008 SPI ADC, for analog sensors such as
+    soil moisture probes or light-dependent resistors.
+    
+    Reports the raw 10-bit reading and the voltage at the pin. A
+    `transform` {"field", "raw": [a, b], "range": [lo, hi], "unit"}
+    also reports `field`, mapping raw a..b linearly onto lo..hi and
+    clamping to that range; a > b is fine for sensors that read lower
+    as the quantity rises (a soil probe reads high when dry).
+    """
+    MAX_RAW = 1023
+    SENSOR_TYPE = "mcp3008"
+    
+    def __init__(self, port: int = 0, cs: pins.PinId = "CE0", channel: int = 0, vref: float = 3.3,
+                 transform: Dict = None, simulated: bool = False):
+        if not 0 <= channel <= 7:
+            raise ValueError(f"MCP3008 channel must be 0-7, got {channel}")
+        pins.bcm(cs)
+        self.channel = channel
+        self.vref = vref
+        self.transform = transform
+        if transform:
+            (self.raw_low, self.raw_high), (self.low, self.high) = transform["raw"], transform["range"]
+            if self.raw_low == self.raw_high:
+                raise ValueError("MCP3008 transform needs two different raw values")
+        self.adc = None
+        if simulated:
+            return
+        try:
+            import digitalio
+            from adafruit_mcp3xxx.mcp3008 import MCP3008 as ADC
+            # SPI0 is on SCK/MOSI/MISO, SPI1 (if enabled) on SCK_1/MOSI_1/MISO_1
+            suffix = f"_{port}" if port else ""
+            spi = busio.SPI(getattr(board, "SCK" + suffix), MOSI=getattr(board, "MOSI" + suffix),
+                            MISO=getattr(board, "MISO" + suffix))
+            select = pins.resolve(cs, output=True)
+            self.adc = ADC(spi, digitalio.DigitalInOut(select), ref_voltage=vref)
+        except Exception as e:
+            print(f"MCP3008 initialization failed: {e}")
+            raise
+    
+    def name(self) -> str:
+        return "MCP3008"
+    
+    def transformed(self, raw: int) -> Tuple[float, bool]:
+        """The transformed value, and whether it had to be clamped to the range."""
+        fraction = (raw - self.raw_low) / (self.raw_high - self.raw_low)
+        clamped = min(1.0, max(0.0, fraction))
+        return self.low + clamped * (self.high - self.low), clamped != fraction
+    
+    def read(self) -> Optional[SensorData]:
+        try:
+            if self.adc is None:
+                # Simulated: a reading wandering around mid-scale
+                raw = min(self.MAX_RAW, max(0, round(random.gauss(512, 20))))
+            else:
+                raw = self.adc.read(self.channel)
+            fields = {
+                "raw": raw,
+                "voltage": raw / self.MAX_RAW * self.vref
+            }
+            quality = Quality.OK
+            if self.transform:
+                fields[self.transform["field"]], clamped = self.transformed(raw)
+                if clamped:
+                    quality |= Quality.CLAMPED
+            return SensorData(
+                sensor_type=self.SENSOR_TYPE,
+                timestamp=self.clock.now(),
+                fields=fields,
+                quality=quality
+            )
+        except Exception as e:
+            print(f"MCP3008 read error: {e}")
+            return None
+    
+    def metadata(self) -> Dict:
+        fields = {
+            'raw': {'unit': '', 'min': 0, 'max': self.MAX_RAW, 'type': 'int'},
+            'voltage': {'unit': 'V', 'min': 0, 'max': self.vref, 'derived': True}
+        }
+        if self.transform:
+            fields[self.transform["field"]] = {'unit': self.transform.get("unit", ""),
+                                               'min': min(self.low, self.high),
+                                               'max': max(self.low, self.high),
+                                               'derived': True}
+        return {'type': self.SENSOR_TYPE, 'fields': fields}
+
+
+class SoilMoisture(MCP3008):
+    """A capacitive soil moisture probe on an MCP3008 channel, reported as 0-100% `moisture`.
+    
+    `air` and `water` are the raw readings with the probe dry in air (0%)
+    and standing in water (100%); readings in between map linearly and
+    beyond them are clamped. Capacitive probes read lower the wetter the
+    soil, so `air` is usually the larger.
+    """
+    SENSOR_TYPE = "soil_moisture"
+    
+    def __init__(self, air: float = 800, water: float = 400, **options):
+        super().__init__(transform={"field": "moisture", "raw": [air, water], "range": [0, 100], "unit": "%"},
+                         **options)
+    
+    def name(self) -> str:
+        return "SOIL_MOISTURE"
+    
+    def calibrate(self, field: str, points: List[Tuple[float, float]]) -> Optional[Dict]:
+        # The readings are `raw` values, as the clamped percentage can't show how far off it is
+        if field != "moisture":
+            return None
+        fit = two_point_calibration(*points[0], *points[1])
+        if fit["scale"] == 0:
+            raise ValueError("the two truth values must differ")
+        air, water = -fit["offset"] / fit["scale"], (100 - fit["offset"]) / fit["scale"]
+        self.raw_low, self.raw_high = air, water
+        self.transform["raw"] = [air, water]
+        return {"air": round(air, 1), "water": round(water, 1)}
+
+
+def unwrap(sensor: Sensor) -> Sensor:
+    """The driver underneath any decorators."""
+    while isinstance(sensor, SensorWrapper):
+        sensor = sensor.sensor
+    return sensor
+
+
+def find_wrapper(sensor: Sensor, cls: type) -> Optional[SensorWrapper]:
+    """The first decorator of type `cls` around a sensor, if any."""
+    while isinstance(sensor, SensorWrapper):
+        if isinstance(sensor, cls):
+            return sensor
+        sensor = sensor.sensor
+    return None
+
+
+def _address(value, default: int) -> int:
+    # JSON has no hex literals, so accept "0x76" as well as 118
+    if value is None:
+        return default
+    return int(value, 0) if isinstance(value, str) else int(value)
+
+
+def _simulation(sensor_type: str, options: Dict) -> Optional[Simulation]:
+    if not options.get("simulated", False):
+        return None
+    return Simulation(sensor_type, options.get("profile"))
+
+
+def create_sensor(sensor_type: str, options: Dict) -> Sensor:
+    if sensor_type == "dht22":
+        # No retries unless configured: with the 2s minimum between reads,
+        # a retry only fits if read_timeout is raised to cover it
+        policy = RetryPolicy.from_dict(options.get("retry", {}),
+                                       RetryPolicy(attempts=1, base_delay=DHT22.MIN_INTERVAL))
+        return DHT22(options.get("pin", "GPIO4"), debug=options.get("debug", False),
+                     start_low_ms=options.get("start_low_ms", 1.0),
+                     start_high_ms=options.get("start_high_ms", 100), pull=options.get("pull", "up"),
+                     bit_threshold_us=options.get("bit_threshold_us", 51),
+                     response_timeout_ms=options.get("response_timeout_ms", 250), retry=policy,
+                     simulation=_simulation(sensor_type, options),
+                     replay=PulseReplay.from_file(options["replay"]) if options.get("replay") else None)
+    if sensor_type == "bmp280":
+        return BMP280(address=_address(options.get("address"), 0x76), simulation=_simulation(sensor_type, options),
+                      reopen_after=options.get("reopen_after", I2C_REOPEN_AFTER))
+    if sensor_type == "bme280":
+        return BME280(address=_address(options.get("address"), 0x76), simulation=_simulation(sensor_type, options),
+                      reopen_after=options.get("reopen_after", I2C_REOPEN_AFTER))
+    if sensor_type == "gy32":
+        return GY32(address=_address(options.get("address"), 0x23), simulation=_simulation(sensor_type, options),
+                    reopen_after=options.get("reopen_after", I2C_REOPEN_AFTER))
+    if sensor_type == "ads1115":
+        return ADS1115(
+            address=_address(options.get("address"), 0x48),
+            channel=options.get("channel", 0),
+            divider=options.get("divider", 1.0),
+            simulated=options.get("simulated", False),
+            reopen_after=options.get("reopen_after", I2C_REOPEN_AFTER)
+        )
+    if sensor_type == "soil_moisture":
+        # Points set through the calibrate endpoint are saved as the moisture calibration
+        moisture = {**options, **options.get("calibration", {}).get("moisture", {})}
+        return SoilMoisture(
+            air=moisture.get("air", 800),
+            water=moisture.get("water", 400),
+            port=options.get("port", 0),
+            cs=options.get("cs", "CE0"),
+            channel=options.get("channel", 0),
+            vref=options.get("vref", 3.3),
+            simulated=options.get("simulated", False)
+        )
+    if sensor_type == "mcp3008":
+        return MCP3008(
+            port=options.get("port", 0),
+            cs=options.get("cs", "CE0"),
+            channel=options.get("channel", 0),
+            vref=options.get("vref", 3.3),
+            transform=options.get("transform"),
+            simulated=options.get("simulated", False)
+        )
+    raise ValueError(f"unknown sensor type {sensor_type!r}")
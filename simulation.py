@@ -0,0 +1,144 @@
+# simulation.py
+import math
+import random
+from abc import ABC, abstractmethod
+from datetime import datetime
+from typing import Dict
+
+
+class Profile(ABC):
+    """How one simulated field behaves over time."""
+    @abstractmethod
+    def value(self, when: datetime) -> float:
+        pass
+
+
+def _hours(when: datetime) -> float:
+    # Hour of the local day, e.g. 14.5 at half past two
+    local = when.astimezone()
+    return local.hour + local.minute / 60 + local.second / 3600
+
+
+class Noise(Profile):
+    """A steady value with Gaussian noise."""
+    def __init__(self, mean: float, noise: float = 0.1):
+        self.mean = mean
+        self.noise = noise
+
+    def value(self, when: datetime) -> float:
+        return random.gauss(self.mean, self.noise)
+
+
+class Diurnal(Profile):
+    """A daily cycle, highest at `peak_hour` local time and lowest 12 hours later.
+
+    A negative amplitude turns it upside down, e.g. for humidity, which
+    is lowest when it is warmest.
+    """
+    def __init__(self, mean: float, amplitude: float, peak_hour: float = 15, noise: float = 0.1):
+        self.mean = mean
+        self.amplitude = amplitude
+        self.peak_hour = peak_hour
+        self.noise = noise
+
+    def value(self, when: datetime) -> float:
+        phase = 2 * math.pi * (_hours(when) - self.peak_hour) / 24
+        return self.mean + self.amplitude * math.cos(phase) + random.gauss(0, self.noise)
+
+
+class Drift(Profile):
+    """A random walk that is pulled back towards `mean`, like barometric pressure.
+
+    Each sample moves by Gaussian `step` plus `pull` times the distance
+    from the mean.
+    """
+    def __init__(self, mean: float, step: float = 0.05, pull: float = 0.001):
+        self.mean = mean
+        self.step = step
+        self.pull = pull
+        self.current = mean
+
+    def value(self, when: datetime) -> float:
+        self.current += random.gauss(0, self.step) + (self.mean - self.current) * self.pull
+        return self.current
+
+
+class Daylight(Profile):
+    """Light following the sun: a half-sine from `sunrise` to `sunset` (local hours).
+
+    Passing clouds dim it by up to `clouds` (a fraction); at night it
+    stays at `night`.
+    """
+    def __init__(self, peak: float, sunrise: float = 6, sunset: float = 20, night: float = 0.0,
+                 clouds: float = 0.3):
+        if sunset <= sunrise:
+            raise ValueError(f"sunset ({sunset}) must be after sunrise ({sunrise})")
+        self.peak = peak
+        self.sunrise = sunrise
+        self.sunset = sunset
+        self.night = night
+        self.clouds = clouds
+
+    def value(self, when: datetime) -> float:
+        hours = _hours(when)
+        if not self.sunrise < hours < self.sunset:
+            return self.night
+        sun = math.sin(math.pi * (hours - self.sunrise) / (self.sunset - self.sunrise))
+        return max(self.night, self.peak * sun * (1 - self.clouds * random.random()))
+
+
+PROFILES = {"noise": Noise, "diurnal": Diurnal, "drift": Drift, "daylight": Daylight}
+
+# What each sensor type reports when simulated, before per-field overrides
+DEFAULTS = {
+    "dht22": {
+        "temperature": {"type": "diurnal", "mean": 21, "amplitude": 3},
+        "humidity": {"type": "diurnal", "mean": 50, "amplitude": -8, "noise": 0.5},
+    },
+    "bmp280": {
+        "temperature": {"type": "diurnal", "mean": 21, "amplitude": 3},
+        "pressure": {"type": "drift", "mean": 1013.25},
+    },
+    "bme280": {
+        "temperature": {"type": "diurnal", "mean": 21, "amplitude": 3},
+        "pressure": {"type": "drift", "mean": 1013.25},
+        "humidity": {"type": "diurnal", "mean": 50, "amplitude": -8, "noise": 0.5},
+    },
+    "gy32": {
+        "lux": {"type": "daylight", "peak": 20000, "night": 0.5},
+    },
+}
+
+
+def create_profile(options: Dict) -> Profile:
+    options = dict(options)
+    kind = options.pop("type", None)
+    if kind not in PROFILES:
+        raise ValueError(f"unknown simulation profile {kind!r}, expected one of {list(PROFILES)}")
+    return PROFILES[kind](**options)
+
+
+class Simulation:
+    """Values for a simulated sensor's fields, one Profile each.
+
+    `overrides` maps field name to profile options; options for a field
+    the defaults already cover are merged into them unless they change
+    its type, e.g. {"temperature": {"amplitude": 8}} for an outdoor sensor.
+    """
+    def __init__(self, sensor_type: str, overrides: Dict[str, Dict] = None):
+        fields = dict(DEFAULTS.get(sensor_type, {}))
+        for key, options in (overrides or {}).items():
+            default = fields.get(key, {})
+            same_type = options.get("type", default.get("type")) == default.get("type")
+            fields[key] = {**default, **options} if same_type else options
+        if not fields:
+            raise ValueError(f"no simulation profiles for {sensor_type}")
+        self.profiles = {}
+        for key, options in fields.items():
+            try:
+                self.profiles[key] = create_profile(options)
+            except (TypeError, ValueError) as e:
+                raise ValueError(f"simulated {sensor_type} field {key!r}: {e}") from e
+
+    def sample(self, when: datetime) -> Dict[str, float]:
+        return {key: profile.value(when) for key, profile in self.profiles.items()}
@@ -0,0 +1,733 @@
+# sinks.py
+import os
+import re
+import gzip
+import asyncio
+import json
+import shutil
+import socket
+import logging
+import threading
+from collections import deque
+from datetime import datetime
+from abc import ABC, abstractmethod
+from typing import Deque, Dict, Iterable, List, Optional, Set, Tuple
+from influxdb_client import BucketRetentionRules, InfluxDBClient, Point, TaskCreateRequest
+from influxdb_client.client.write_api import SYNCHRONOUS
+from influxdb_client.service.ping_service import PingService
+from urllib3.exceptions import MaxRetryError, TimeoutError as HTTPTimeout
+from aggregate import FUNCTIONS as AGGREGATES
+from clock import SYSTEM, Clock
+from retry import RetryPolicy, retry
+import metrics
+from sensors import SensorData
+
+logger = logging.getLogger(__name__)
+
+
+class Sink(ABC):
+    """Somewhere readings are stored."""
+    # Whether write() may be called from several threads at once
+    concurrent = False
+    # Why the last write wasn't stored, None once one is, for the status endpoints
+    last_error: Optional[str] = None
+
+    @abstractmethod
+    def write(self, data: SensorData) -> Optional[str]:
+        """Stores a reading, returning why it wasn't stored, None if it was.
+
+        A sink that keeps what it couldn't store to retry later still
+        returns the error, so the caller counts the write as failed.
+        """
+
+    @abstractmethod
+    def name(self) -> str:
+        pass
+
+    def write_batch(self, batch: List[SensorData]):
+        """Writes a batch, raising if it wasn't stored (write() returns failures instead)."""
+        for data in batch:
+            self.write(data)
+        self.flush()
+
+    def flush(self):
+        pass
+
+    def close(self):
+        self.flush()
+
+    def unsent(self) -> List:
+        """What is still waiting to be stored, JSON-encodable, to keep across a restart."""
+        return []
+
+    def restore(self, items: List):
+        """Takes back what unsent() returned before the restart."""
+
+    def backlog_size(self) -> int:
+        """How many readings (or rows) the sink holds that aren't stored yet."""
+        return 0
+
+
+class InfluxTimeout(TimeoutError):
+    """InfluxDB didn't answer within the client timeout."""
+
+
+class InfluxSink(Sink):
+    """Writes each reading as one point of the sensor_data measurement.
+
+    A reading's metadata (its sensor type as "sensor", plus tags such as
+    device and location) is split by `tag_keys`: those keys become
+    InfluxDB tags, the rest string fields. Tags are indexed, so they are
+    what you group and filter by, but every distinct combination of tag
+    values creates a new series. Anything with many possible values (ids,
+    counters, timestamps, free text) belongs in a field, or the series
+    count grows without bound and queries and memory suffer.
+
+    While InfluxDB can't be reached, readings are kept in a backlog of up
+    to `backlog_size` (oldest dropped first) and written once a write
+    gets through again. Readings InfluxDB rejects are not kept.
+    """
+
+    # The client's HTTP writes are independent of each other
+    concurrent = True
+
+    # Keys that make poor tags because nearly every reading has a new value
+    HIGH_CARDINALITY = {"id", "uuid", "seq", "time", "timestamp", "value", "message", "error"}
+    # Distinct values seen for one tag key before warning about it
+    CARDINALITY_WARNING = 100
+    # Points per request when writing the backlog
+    REPLAY_BATCH = 500
+    # Sensor failures, to overlay on the sensor_data panels; always tagged by sensor and error type
+    EVENTS = "events"
+    EVENT_TAG_KEYS = {"sensor", "error_type"}
+
+    def __init__(self, url: str, token: str, org: str, bucket: str,
+                 tag_keys: Set[str] = frozenset({"sensor", "device"}), retry: RetryPolicy = None,
+                 timeout: float = 10.0, backlog_size: int = 10000):
+        self.url = url
+        self.token = token
+        self.org = org
+        self.bucket = bucket
+        self.tag_keys = set(tag_keys)
+        self.retry = retry or RetryPolicy()
+        # Seconds any one call (write, ping) may take, so a hung connection can't hold a worker
+        self.timeout = timeout
+        self.client = None
+        self.write_api = None
+        self.healthy = False
+        # Why the last call failed, for /readyz and /api/status
+        self.last_error = None
+        self.timed_out = False
+        self.tag_values: Dict[str, Set[str]] = {}
+        self.warned: Set[str] = set()
+        self.backlog: Deque[SensorData] = deque(maxlen=backlog_size)
+        self.overflowed = False
+        # Held by the one worker writing the backlog
+        self.replaying = threading.Lock()
+
+        for key in self.tag_keys & self.HIGH_CARDINALITY:
+            logger.warning(f"InfluxDB tag '{key}' is likely high-cardinality, consider storing it as a field")
+
+    def name(self) -> str:
+        return "influxdb"
+
+    def _call(self, fn, *args, **kwargs):
+        """Calls the client, raising InfluxTimeout for a timeout and recording the outcome."""
+        try:
+            result = fn(*args, **kwargs)
+        except Exception as e:
+            # urllib3 wraps the timeout in MaxRetryError when the client retries
+            reason = e.reason if isinstance(e, MaxRetryError) else e
+            self.timed_out = isinstance(reason, (HTTPTimeout, socket.timeout))
+            if self.timed_out:
+                self.healthy = False
+                self.last_error = f"no answer from InfluxDB at {self.url} within {self.timeout}s"
+                raise InfluxTimeout(self.last_error) from e
+            self.last_error = str(e)
+            raise
+        self.timed_out = False
+        self.last_error = None
+        return result
+
+    def _ping(self):
+        # client.ping() swallows the error; the service raises it, so a timeout can be told apart
+        self._call(PingService(self.client.api_client).get_ping)
+
+    def _connect(self):
+        if self.client is None:
+            logger.info("Initializing InfluxDB client...")
+            self.client = InfluxDBClient(url=self.url, token=self.token, org=self.org,
+                                         timeout=int(self.timeout * 1000))
+            self.write_api = self.client.write_api(write_options=SYNCHRONOUS)
+
+        # Test the connection
+        self._ping()
+        self.healthy = True
+        logger.info("✓ InfluxDB client initialized successfully")
+
+    def connect(self):
+        # At boot the network or InfluxDB itself may take a moment to come up
+        try:
+            retry(self._connect, self.retry, what="InfluxDB connection")
+        except Exception as e:
+            logger.error(f"✗ InfluxDB initialization failed: {e}")
+
+    def check_health(self):
+        if self.client is None:
+            self.connect()
+            return
+
+        # A timeout marks the sink unhealthy itself, so compare with the state from before
+        was_healthy = self.healthy
+        try:
+            self._ping()
+            healthy = True
+        except Exception:
+            healthy = False
+
+        if healthy and not was_healthy:
+            logger.info("✓ InfluxDB reachable again, resuming writes")
+        elif not healthy and was_healthy:
+            logger.error(f"✗ InfluxDB health check failed at {self.url}")
+        self.healthy = healthy
+
+    def _point(self, data: SensorData) -> Point:
+        # Keep the acquisition time, not the time of writing
+        timestamp = data.timestamp
+
+        point = Point(data.measurement or "sensor_data").time(timestamp)
+        tag_keys = self.tag_keys | self.EVENT_TAG_KEYS if data.measurement == self.EVENTS else self.tag_keys
+
+        for key, value in {"sensor": data.sensor_type, **data.tags}.items():
+            if key in tag_keys:
+                self._track_cardinality(key, value)
+                point = point.tag(key, value)
+            else:
+                point = point.field(key, str(value))
+
+        for key, value in data.fields.items():
+            if isinstance(value, (bool, int, str)):
+                # Written with their own InfluxDB type: boolean, integer or string
+                point = point.field(key, value)
+            else:
+                point = point.field(key, float(value))
+        if data.seq is not None:
+            point = point.field("seq", data.seq)
+        if data.quality:
+            # Only flagged readings carry it; a tag if listed in tag_keys, to filter on
+            quality = ",".join(data.quality.names())
+            point = point.tag("quality", quality) if "quality" in self.tag_keys else point.field("quality", quality)
+
+        logger.debug(f"Writing point: measurement={data.measurement or 'sensor_data'}, tag=sensor:{data.sensor_type}, fields={data.fields}, time={timestamp}")
+        return point
+
+    @staticmethod
+    def _rejected(e: Exception) -> bool:
+        # A 4xx (bad field type, unknown bucket) fails again however often it is retried
+        status = getattr(e, "status", None)
+        return isinstance(status, int) and 400 <= status < 500
+
+    def _hold(self, data: SensorData):
+        if not self.backlog:
+            logger.warning("InfluxDB unavailable, keeping readings until it is back")
+            self.overflowed = False
+        elif len(self.backlog) == self.backlog.maxlen and not self.overflowed:
+            logger.warning(f"InfluxDB backlog full ({self.backlog.maxlen}), dropping the oldest readings")
+            self.overflowed = True
+        self.backlog.append(data)
+
+    def write(self, data: SensorData) -> Optional[str]:
+        if self.write_api is None:
+            error = self.last_error or "InfluxDB is not connected"
+            self.last_error = error
+            self._hold(data)
+            return error
+
+        try:
+            point = self._point(data)
+
+            # Write with explicit bucket and org
+            self._call(self.write_api.write, bucket=self.bucket, org=self.org, record=point)
+
+            logger.info(f"✓ Written to InfluxDB: {data.sensor_type} - {data.fields}")
+        except InfluxTimeout as e:
+            # Unhealthy until the next health check gets an answer
+            logger.error(f"✗ InfluxDB write timed out: {e}")
+            self._hold(data)
+            return str(e)
+        except Exception as e:
+            logger.error(f"✗ InfluxDB write error: {e}", exc_info=True)
+            self.last_error = str(e)
+            if not self._rejected(e):
+                self._hold(data)
+            return str(e)
+        if self.backlog:
+            # Reachable again
+            self.flush()
+        return None
+
+    def flush(self):
+        """Writes the backlog, if InfluxDB takes it."""
+        if not self.backlog or self.write_api is None or not self.replaying.acquire(blocking=False):
+            return
+        try:
+            while self.backlog:
+                batch = [self.backlog[i] for i in range(min(self.REPLAY_BATCH, len(self.backlog)))]
+                try:
+                    self.write_batch(batch)
+                except Exception as e:
+                    if not self._rejected(e):
+                        logger.warning(f"InfluxDB backlog not written, {len(self.backlog)} reading(s) kept: {e}")
+                        return
+                    logger.error(f"✗ InfluxDB rejected {len(batch)} backlog reading(s), dropping them: {e}")
+                # Other workers only append; a full backlog may have dropped some of the batch already
+                for data in batch:
+                    if self.backlog and self.backlog[0] is data:
+                        self.backlog.popleft()
+            logger.info("✓ InfluxDB backlog written")
+        finally:
+            self.replaying.release()
+
+    def unsent(self) -> List:
+        return [data.to_dict() for data in self.backlog]
+
+    def backlog_size(self) -> int:
+        return len(self.backlog)
+
+    def restore(self, items: List):
+        for item in items:
+            self.backlog.append(SensorData.from_dict(item))
+
+    def write_batch(self, batch: List[SensorData]):
+        if self.write_api is None:
+            raise ConnectionError("InfluxDB is not connected")
+        # One request for the whole batch
+        self._call(self.write_api.write, bucket=self.bucket, org=self.org,
+                   record=[self._point(data) for data in batch])
+
+    # Aggregates a downsampling task may use
+    DOWNSAMPLE_FUNCTIONS = {"mean", "median", "min", "max", "last", "sum"}
+    DOWNSAMPLE_FLUX = """import "types"
+
+option task = {{name: "{name}", every: {every}}}
+
+from(bucket: "{source}")
+    |> range(start: -task.every)
+    |> filter(fn: (r) => r._measurement == "sensor_data" and types.isNumeric(v: r._value))
+    |> aggregateWindow(every: task.every, fn: {fn}, createEmpty: false)
+    |> to(bucket: "{target}", org: "{org}")
+"""
+
+    def ensure_downsampling(self, target: str, every: str = "1h", fn: str = "mean", retention: int = 0):
+        """Makes sure an InfluxDB task rolls numeric fields up into `target` every `every`.
+
+        `target` is created with `retention` seconds of retention (0 keeps
+        everything) if it doesn't exist. A task already there under the same
+        name is left as it is, even if it was edited by hand.
+        """
+        if not re.fullmatch(r"\d+(ms|s|m|h|d|w)", every):
+            raise ValueError(f"downsampling interval must be a Flux duration such as 1h, got {every!r}")
+        if fn not in self.DOWNSAMPLE_FUNCTIONS:
+            raise ValueError(f"downsampling function must be one of {sorted(self.DOWNSAMPLE_FUNCTIONS)}, got {fn!r}")
+        buckets = self.client.buckets_api()
+        if buckets.find_bucket_by_name(target) is None:
+            rules = [BucketRetentionRules(type="expire", every_seconds=retention)] if retention else []
+            buckets.create_bucket(bucket_name=target, retention_rules=rules, org=self.org)
+            logger.info(f"✓ Created InfluxDB bucket {target}")
+        name = f"iotgo-downsample-{target}"
+        tasks = self.client.tasks_api()
+        if tasks.find_tasks(name=name):
+            logger.info(f"✓ InfluxDB downsampling task {name} already exists")
+            return
+        flux = self.DOWNSAMPLE_FLUX.format(name=name, every=every, source=self.bucket, fn=fn, target=target,
+                                           org=self.org)
+        tasks.create_task(task_create_request=TaskCreateRequest(org=self.org, flux=flux, status="active",
+                                                                description=f"{fn} of {self.bucket} per {every}"))
+        logger.info(f"✓ Created InfluxDB task {name}: {fn} of {self.bucket} per {every} into {target}")
+
+    def _track_cardinality(self, key: str, value: str):
+        if key in self.warned:
+            return
+        values = self.tag_values.setdefault(key, set())
+        values.add(value)
+        if len(values) > self.CARDINALITY_WARNING:
+            logger.warning(f"InfluxDB tag '{key}' has over {self.CARDINALITY_WARNING} distinct values, "
+                           f"consider storing it as a field")
+            self.warned.add(key)
+            del self.tag_values[key]
+
+    def close(self):
+        if self.client:
+            self.client.close()
+
+
+class TimescaleSink(Sink):
+    """Stores one row per field in a TimescaleDB hypertable.
+
+    Rows are batched and inserted with a single multi-row INSERT once
+    `batch_size` rows are pending or `flush_interval` seconds have passed.
+    If the database is unreachable the rows stay pending (up to
+    `max_pending`, oldest dropped first) and the connection is retried on
+    the next flush.
+    """
+    SCHEMA = """
+        CREATE TABLE IF NOT EXISTS {table} (
+            time TIMESTAMPTZ NOT NULL,
+            sensor TEXT NOT NULL,
+            field TEXT NOT NULL,
+            value DOUBLE PRECISION,
+            tags JSONB
+        );
+        ALTER TABLE {table} ADD COLUMN IF NOT EXISTS value_text TEXT;
+        SELECT create_hypertable('{table}', 'time', if_not_exists => TRUE);
+    """
+
+    def __init__(self, dsn: str, table: str = "sensor_data", batch_size: int = 100,
+                 flush_interval: float = 5, max_pending: int = 10000, timeout: int = 5, clock: Clock = SYSTEM):
+        self.dsn = dsn
+        self.table = table
+        self.batch_size = batch_size
+        self.flush_interval = flush_interval
+        self.max_pending = max_pending
+        self.timeout = timeout
+        self.clock = clock
+        self.conn = None
+        self.pending: List[Tuple] = []
+        self.last_flush = self.clock.monotonic()
+
+    def name(self) -> str:
+        return "timescaledb"
+
+    def _connect(self):
+        if self.conn is None or self.conn.closed:
+            import psycopg2
+            self.conn = psycopg2.connect(
+                self.dsn,
+                connect_timeout=self.timeout,
+                options=f"-c statement_timeout={self.timeout * 1000}"
+            )
+        return self.conn
+
+    def create_schema(self):
+        conn = self._connect()
+        with conn, conn.cursor() as cur:
+            cur.execute(self.SCHEMA.format(table=self.table))
+        logger.info(f"✓ TimescaleDB table {self.table} ready")
+
+    def write(self, data: SensorData) -> Optional[str]:
+        tags = dict(data.tags)
+        if data.seq is not None:
+            tags["seq"] = data.seq
+        if data.quality:
+            tags["quality"] = data.quality.names()
+        tags = json.dumps(tags)
+        for key, value in data.fields.items():
+            # Booleans and ints are stored as numbers, strings in value_text
+            number, text = (None, value) if isinstance(value, str) else (float(value), None)
+            self.pending.append((data.timestamp.astimezone(), data.sensor_type, key, number, text, tags))
+        if len(self.pending) > self.max_pending:
+            dropped = len(self.pending) - self.max_pending
+            del self.pending[:dropped]
+            logger.warning(f"TimescaleDB backlog full, dropped {dropped} row(s)")
+
+        due = self.clock.monotonic() - self.last_flush >= self.flush_interval
+        if len(self.pending) >= self.batch_size or due:
+            return self.flush()
+        return None
+
+    def write_batch(self, batch: List[SensorData]):
+        super().write_batch(batch)
+        # flush() keeps the rows it couldn't insert
+        if self.pending:
+            raise ConnectionError("TimescaleDB write failed, see the log")
+
+    def flush(self) -> Optional[str]:
+        """Inserts the pending rows, returning why they weren't, None if they were."""
+        self.last_flush = self.clock.monotonic()
+        if not self.pending:
+            return None
+        rows = self.pending
+        try:
+            from psycopg2.extras import execute_values
+            conn = self._connect()
+            with conn, conn.cursor() as cur:
+                execute_values(
+                    cur,
+                    f"INSERT INTO {self.table} (time, sensor, field, value, value_text, tags) VALUES %s",
+                    rows,
+                    page_size=self.batch_size
+                )
+            self.pending = []
+            self.last_error = None
+            logger.info(f"✓ Written {len(rows)} row(s) to TimescaleDB")
+            return None
+        except Exception as e:
+            logger.error(f"✗ TimescaleDB write error: {e}")
+            self.last_error = str(e)
+            if self.conn is not None:
+                self.conn.close()
+                self.conn = None
+            return str(e)
+
+    def close(self):
+        self.flush()
+        if self.conn is not None:
+            self.conn.close()
+
+    def unsent(self) -> List:
+        return [[row[0].isoformat(), *row[1:]] for row in self.pending]
+
+    def backlog_size(self) -> int:
+        return len(self.pending)
+
+    def restore(self, items: List):
+        rows = [(datetime.fromisoformat(item[0]), *item[1:]) for item in items]
+        # Older than anything written since startup, so they go first
+        self.pending = (rows + self.pending)[-self.max_pending:]
+
+
+class FileSink(Sink):
+    """Appends one JSON object per reading to a local NDJSON file.
+
+    The file is rotated when it grows past `max_bytes` or is older than
+    `rotate_seconds` (0 disables either); rotated files get a timestamp
+    suffix and are gzipped if `compress` is set. Buffered lines are
+    flushed every `flush_interval` seconds and on close.
+    """
+    def __init__(self, path: str, max_bytes: int = 10 * 1024 * 1024, rotate_seconds: float = 0,
+                 compress: bool = False, flush_interval: float = 5, clock: Clock = SYSTEM):
+        self.path = path
+        self.max_bytes = max_bytes
+        self.rotate_seconds = rotate_seconds
+        self.compress = compress
+        self.flush_interval = flush_interval
+        self.clock = clock
+        self.file = None
+        self.opened_at = 0.0
+        self.last_flush = self.clock.monotonic()
+
+    def name(self) -> str:
+        return "file"
+
+    def _open(self):
+        directory = os.path.dirname(self.path)
+        if directory:
+            os.makedirs(directory, exist_ok=True)
+        self.file = open(self.path, "a", encoding="utf-8")
+        # Time-based rotation counts from when this process opened the file
+        self.opened_at = self.clock.monotonic()
+
+    def _should_rotate(self) -> bool:
+        if self.max_bytes and self.file.tell() >= self.max_bytes:
+            return True
+        return bool(self.rotate_seconds) and self.clock.monotonic() - self.opened_at >= self.rotate_seconds
+
+    def _rotate(self):
+        self.file.close()
+        self.file = None
+        # Local time, as the rest of the file name is for people
+        base = f"{self.path}.{self.clock.now().astimezone().strftime('%Y%m%dT%H%M%S')}"
+        rotated, n = base, 0
+        while os.path.exists(rotated) or os.path.exists(rotated + ".gz"):
+            n += 1
+            rotated = f"{base}-{n}"
+        os.replace(self.path, rotated)
+        if self.compress:
+            with open(rotated, "rb") as src, gzip.open(rotated + ".gz", "wb") as dst:
+                shutil.copyfileobj(src, dst)
+            os.remove(rotated)
+            rotated += ".gz"
+        logger.info(f"✓ Rotated {self.path} to {rotated}")
+
+    def write(self, data: SensorData) -> Optional[str]:
+        try:
+            if self.file is None:
+                self._open()
+            self.file.write(json.dumps(data.to_dict()) + "\n")
+            if self.clock.monotonic() - self.last_flush >= self.flush_interval:
+                self.flush()
+            if self._should_rotate():
+                self._rotate()
+            self.last_error = None
+            return None
+        except Exception as e:
+            logger.error(f"✗ File sink write error: {e}")
+            self.last_error = str(e)
+            return str(e)
+
+    def flush(self):
+        self.last_flush = self.clock.monotonic()
+        if self.file is not None:
+            self.file.flush()
+
+    def close(self):
+        if self.file is not None:
+            self.file.close()
+            self.file = None
+
+
+class FieldFilter:
+    """Which fields a sink stores: those in `include` (all when empty), minus `exclude`.
+
+    A field aggregated or summarized as `<field>_<function>` (temperature_mean,
+    temperature_max_at) goes with its field, unless it is listed itself.
+    """
+    # Longest first, so temperature_min_at isn't taken for a field named temperature_min
+    SUFFIXES = sorted([f"_{name}" for name in AGGREGATES] + ["_min_at", "_max_at"], key=len, reverse=True)
+
+    def __init__(self, include: Iterable[str] = (), exclude: Iterable[str] = ()):
+        self.include = set(include)
+        self.exclude = set(exclude)
+
+    def _base(self, key: str) -> Optional[str]:
+        return next((key[:-len(suffix)] for suffix in self.SUFFIXES if key.endswith(suffix)), None)
+
+    def keeps(self, key: str) -> bool:
+        if key in self.exclude:
+            return False
+        if key in self.include:
+            return True
+        base = self._base(key)
+        if base in self.exclude:
+            return False
+        return not self.include or base in self.include
+
+    def apply(self, data: SensorData) -> Optional[SensorData]:
+        """The reading with only the stored fields, or None if none are left."""
+        if not self.include and not self.exclude:
+            return data
+        fields = {key: value for key, value in data.fields.items() if self.keeps(key)}
+        return data.copy(fields=fields) if fields else None
+
+
+class SinkWriter:
+    """Feeds one sink from a bounded queue, so slow storage never holds up reading.
+
+    Writes run in worker threads: `workers` of them for sinks that allow
+    concurrent writes, otherwise one, which also keeps them in order.
+    When the queue is full, `policy` "drop" discards the oldest queued
+    reading and "block" keeps the new one waiting for room, up to `size`
+    more, without holding up the caller; past that the new one is dropped.
+
+    Each sink has its own writer, so one that fails or hangs only fills
+    its own queue. It counts as unhealthy from a failed write (one that
+    raised or returned an error) until the next one succeeds, or while a write has been running for longer than
+    `stall_after` seconds.
+    """
+    POLICIES = ("drop", "block")
+
+    def __init__(self, sink: Sink, size: int = 1000, policy: str = "drop", workers: int = 1,
+                 fields: FieldFilter = None, stall_after: float = 30, clock: Clock = SYSTEM):
+        if policy not in self.POLICIES:
+            raise ValueError(f"write queue policy must be one of {self.POLICIES}, got {policy!r}")
+        self.sink = sink
+        self.fields = fields or FieldFilter()
+        self.policy = policy
+        self.workers = workers if sink.concurrent else 1
+        self.stall_after = stall_after
+        self.clock = clock
+        self.queue: asyncio.Queue = asyncio.Queue(maxsize=size)
+        self.tasks: List[asyncio.Task] = []
+        # Readings waiting for room under the "block" policy
+        self.waiting: Set[asyncio.Task] = set()
+        self.written = 0
+        self.failed = 0
+        self.dropped_count = 0
+        self.consecutive_failures = 0
+        self.last_error: Optional[str] = None
+        self.last_error_at: Optional[datetime] = None
+        self.last_write_at: Optional[datetime] = None
+        # Monotonic start of each write in progress, by worker
+        self.writing: Dict[int, float] = {}
+        self.dropped = metrics.write_dropped.labels(sink=sink.name())
+        self.errors = metrics.write_errors.labels(sink=sink.name())
+        metrics.write_queue_depth.labels(sink=sink.name()).set_function(self.queue.qsize)
+        metrics.write_healthy.labels(sink=sink.name()).set_function(lambda: int(self.healthy()))
+
+    def start(self):
+        self.tasks = [asyncio.create_task(self._work()) for _ in range(self.workers)]
+
+    def _drop(self, what: str):
+        self.dropped_count += 1
+        self.dropped.inc()
+        logger.warning(f"{self.sink.name()} write queue full, dropped {what}")
+
+    def put(self, data: SensorData):
+        """Queues `data`; never waits, whatever the policy."""
+        if not self.queue.full() and not self.waiting:
+            self.queue.put_nowait(data)
+            return
+        if self.policy == "block":
+            if len(self.waiting) >= self.queue.maxsize:
+                self._drop("the newest reading")
+                return
+            # Queue.put wakes waiters in order, so readings still reach the sink in order
+            task = asyncio.create_task(self.queue.put(data))
+            self.waiting.add(task)
+            task.add_done_callback(self.waiting.discard)
+            return
+        self.queue.get_nowait()
+        self.queue.task_done()
+        self._drop("the oldest reading")
+        self.queue.put_nowait(data)
+
+    async def _work(self):
+        worker = id(asyncio.current_task())
+        while True:
+            data = await self.queue.get()
+            self.writing[worker] = self.clock.monotonic()
+            try:
+                # Each write's own result: with several workers, the sink's
+                # last_error may already belong to another one
+                error = await asyncio.to_thread(self.sink.write, data)
+            except Exception as e:
+                error = str(e)
+            finally:
+                self.writing.pop(worker, None)
+                self.queue.task_done()
+            if error:
+                # Once per outage, or the log fills with one line per reading
+                if not self.consecutive_failures or error != self.last_error:
+                    logger.error(f"✗ {self.sink.name()} write failed: {error}")
+                self.failed += 1
+                self.consecutive_failures += 1
+                self.last_error, self.last_error_at = error, self.clock.now()
+                self.errors.inc()
+            else:
+                if self.consecutive_failures:
+                    logger.info(f"✓ {self.sink.name()} writes succeeding again")
+                self.written += 1
+                self.consecutive_failures = 0
+                self.last_write_at = self.clock.now()
+
+    def stalled_for(self) -> float:
+        """Seconds the oldest write in progress has been running, 0 if none."""
+        return self.clock.monotonic() - min(self.writing.values()) if self.writing else 0
+
+    def healthy(self) -> bool:
+        return self.consecutive_failures == 0 and self.stalled_for() <= self.stall_after
+
+    def status(self) -> Dict:
+        def iso(at):
+            return at.isoformat() if at else None
+        return {
+            "sink": self.sink.name(), "healthy": self.healthy(), "queued": self.queue.qsize(),
+            "waiting": len(self.waiting), "capacity": self.queue.maxsize, "policy": self.policy,
+            "workers": self.workers, "written": self.written, "failed": self.failed,
+            "dropped": self.dropped_count, "backlog": self.sink.backlog_size(),
+            "consecutive_failures": self.consecutive_failures, "write_running_seconds": round(self.stalled_for(), 1),
+            "last_write_at": iso(self.last_write_at), "last_error": self.last_error,
+            "last_error_at": iso(self.last_error_at),
+        }
+
+    async def drain(self):
+        """Waits until everything queued has been written."""
+        while self.waiting:
+            await asyncio.gather(*self.waiting, return_exceptions=True)
+        await self.queue.join()
+
+    async def stop(self):
+        for task in self.tasks + list(self.waiting):
+            task.cancel()
+        await asyncio.gather(*self.tasks, *self.waiting, return_exceptions=True)
+        self.tasks = []
@@ -0,0 +1,123 @@
+# summary.py
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+from clock import SYSTEM, Clock
+from sensors import SensorData, is_numeric
+
+# Summaries are written to their own InfluxDB measurement
+MEASUREMENT = "sensor_summary"
+
+PERIODS = {"hourly": timedelta(hours=1), "daily": timedelta(days=1)}
+
+# Fields whose high and low are also reported with the time they happened
+TIMED_FIELDS = {"temperature"}
+
+
+def period_start(period: str, when: datetime) -> datetime:
+    """Start of the period containing `when`, on the local clock."""
+    local = when.astimezone().replace(tzinfo=None, minute=0, second=0, microsecond=0)
+    if period == "daily":
+        local = local.replace(hour=0)
+    # Re-attach the offset in effect at that local time, right across DST changes
+    return local.astimezone()
+
+
+def next_boundary(period: str, when: datetime) -> datetime:
+    start = period_start(period, when).replace(tzinfo=None)
+    return (start + PERIODS[period]).astimezone()
+
+
+class Summary:
+    """One sensor's summary of one period, also shaped to go to notifiers."""
+    def __init__(self, data: SensorData, period: str, start: datetime, partial: bool):
+        self.data = data
+        self.period = period
+        self.start = start
+        self.partial = partial
+
+    def text(self) -> str:
+        lines = [f"📊 {self.data.sensor_type.upper()} {self.period} summary for "
+                 f"{self.start.strftime('%Y-%m-%d %H:%M')}" + (" (partial)" if self.partial else "")]
+        for key, value in self.data.fields.items():
+            lines.append(f"{key}: {value:.2f}" if isinstance(value, float) else f"{key}: {value}")
+        return "\n".join(lines)
+
+    def to_dict(self) -> Dict:
+        return {**self.data.to_dict(), 'period': self.period, 'partial': self.partial}
+
+
+class Rollup:
+    """Running min, max and mean of each numeric field over one period."""
+    def __init__(self, period: str, start: datetime, partial: bool):
+        self.period = period
+        self.start = start
+        self.partial = partial
+        self.stats: Dict[str, Dict] = {}
+        self.last: Optional[SensorData] = None
+
+    def add(self, data: SensorData):
+        self.last = data
+        for key, value in data.fields.items():
+            # Booleans have no meaningful min/max/mean over an hour
+            if not is_numeric(value) or isinstance(value, bool):
+                continue
+            stats = self.stats.get(key)
+            if stats is None:
+                self.stats[key] = {"min": value, "max": value, "sum": value, "count": 1,
+                                   "min_at": data.timestamp, "max_at": data.timestamp}
+                continue
+            stats["sum"] += value
+            stats["count"] += 1
+            if value < stats["min"]:
+                stats["min"], stats["min_at"] = value, data.timestamp
+            if value > stats["max"]:
+                stats["max"], stats["max_at"] = value, data.timestamp
+
+    def summary(self) -> Optional[Summary]:
+        if not self.stats:
+            return None
+        fields = {}
+        for key, stats in self.stats.items():
+            fields[f"{key}_min"] = float(stats["min"])
+            fields[f"{key}_max"] = float(stats["max"])
+            fields[f"{key}_mean"] = stats["sum"] / stats["count"]
+            fields[f"{key}_count"] = stats["count"]
+            if key in TIMED_FIELDS:
+                fields[f"{key}_min_at"] = stats["min_at"].isoformat()
+                fields[f"{key}_max_at"] = stats["max_at"].isoformat()
+        tags = {**self.last.tags, "period": self.period}
+        data = SensorData(self.last.sensor_type, fields, timestamp=self.start, tags=tags,
+                          measurement=MEASUREMENT)
+        return Summary(data, self.period, self.start, self.partial)
+
+
+class Summarizer:
+    """Rolls readings up into hourly and/or daily summaries per sensor.
+
+    A period that was already underway when the process started is still
+    summarized from the readings seen, and flagged as partial.
+    """
+    def __init__(self, periods: List[str], clock: Clock = SYSTEM):
+        unknown = set(periods) - set(PERIODS)
+        if unknown:
+            raise ValueError(f"unknown summary period(s): {sorted(unknown)}, expected {list(PERIODS)}")
+        self.periods = periods
+        self.started = clock.now()
+        self.rollups: Dict[Tuple[str, str], Rollup] = {}
+
+    def add(self, name: str, data: SensorData):
+        for period in self.periods:
+            rollup = self.rollups.get((period, name))
+            if rollup is None:
+                start = period_start(period, data.timestamp)
+                rollup = self.rollups[(period, name)] = Rollup(period, start, partial=self.started > start)
+            rollup.add(data)
+
+    def close(self, period: str) -> List[Summary]:
+        """Summaries of every sensor's current `period`, starting new ones."""
+        summaries = []
+        for key in [key for key in self.rollups if key[0] == period]:
+            summary = self.rollups.pop(key).summary()
+            if summary:
+                summaries.append(summary)
+        return summaries
@@ -0,0 +1,125 @@
+# fakes.py: stand-ins shared by the tests
+import asyncio
+from types import SimpleNamespace
+from typing import Dict, List, Optional
+from aiohttp import WSMsgType
+from clock import FakeClock
+from sensors import Sensor, SensorData
+
+
+class FakeSensor(Sensor):
+    """Returns the readings it is given, in order, stamped by its FakeClock."""
+    def __init__(self, name: str = "fake", fields: Dict = None, readings: List[Dict] = (),
+                 clock: FakeClock = None):
+        self._name = name
+        self.fields = fields or {"temperature": {"unit": "°C", "min": -40, "max": 80}}
+        self.readings = list(readings)
+        self.clock = clock or FakeClock()
+
+    def name(self) -> str:
+        return self._name
+
+    def metadata(self) -> Dict:
+        return {"type": self._name.lower(), "fields": self.fields}
+
+    def read(self) -> Optional[SensorData]:
+        if not self.readings:
+            return None
+        return SensorData(self._name.lower(), dict(self.readings.pop(0)), timestamp=self.clock.now())
+
+
+class FakeRequest(dict):
+    """Enough of an aiohttp request for middlewares and simple handlers."""
+    def __init__(self, app, path: str, token: str = None, body=None, match_info: Dict = None, method: str = "GET"):
+        super().__init__()
+        self.app = app
+        self.path = path
+        self.method = method
+        self.headers = {"Authorization": f"Bearer {token}"} if token else {}
+        self.query = {}
+        self.match_info = match_info or {}
+        self.body = body
+        self.remote = "127.0.0.1"
+        self.transport = None
+
+    async def json(self):
+        return self.body
+
+
+class FakeTransport:
+    def __init__(self):
+        self.closing = False
+
+    def is_closing(self) -> bool:
+        return self.closing
+
+
+class FakeWebSocket:
+    """Stands in for a WebSocketResponse: `receive` and `disconnect` play
+    the client's side, `sent` collects every frame the server wrote.
+    """
+    def __init__(self):
+        self.sent: List[str] = []
+        self.incoming: asyncio.Queue = asyncio.Queue()
+        self.closed = False
+        self.close_code = None
+        self.close_calls = 0
+        # Set to make the next writes fail, as on a connection that dropped without a close frame
+        self.broken = False
+
+    async def prepare(self, request):
+        pass
+
+    async def send_str(self, data: str):
+        if self.closed or self.broken:
+            raise ConnectionResetError("WebSocket is closed")
+        self.sent.append(data)
+
+    async def close(self, code: int = 1000, message: bytes = b""):
+        self.close_calls += 1
+        self.closed = True
+        self.close_code = code
+        self.incoming.put_nowait(None)
+
+    def exception(self):
+        return None
+
+    def receive(self, text: str):
+        self.incoming.put_nowait(SimpleNamespace(type=WSMsgType.TEXT, data=text))
+
+    def disconnect(self):
+        self.closed = True
+        self.incoming.put_nowait(None)
+
+    def __aiter__(self):
+        return self
+
+    async def __anext__(self):
+        message = await self.incoming.get()
+        if message is None:
+            raise StopAsyncIteration
+        return message
+
+
+class FakeStream:
+    """Stands in for the StreamResponse behind SSE; `events` are the data: payloads written."""
+    def __init__(self, headers: Dict = None):
+        self.headers = headers or {}
+        self.events: List[str] = []
+
+    async def prepare(self, request):
+        pass
+
+    async def write(self, data: bytes):
+        self.events.append(data.decode().removeprefix("data: ").rstrip("\n"))
+
+
+async def until(condition, timeout: float = 5.0):
+    """Waits for `condition()` to hold, letting the event loop run in between."""
+    async with asyncio.timeout(timeout):
+        while not condition():
+            await asyncio.sleep(0.001)
+
+
+def run(coroutine):
+    return asyncio.run(coroutine)
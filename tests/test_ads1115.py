@@ -0,0 +1,44 @@
+import unittest
+from types import SimpleNamespace
+from sensors import ADS1115, create_sensor
+
+
+class ADS1115Test(unittest.TestCase):
+    def adc(self, measured, divider):
+        sensor = ADS1115(divider=divider, simulated=True)
+        sensor.channel = SimpleNamespace(voltage=measured)
+        return sensor
+
+    def test_divider(self):
+        # 100k/33k divider: 12.6V at the battery is 3.126V at the ADC
+        sensor = self.adc(3.126, 133 / 33)
+        self.assertAlmostEqual(sensor.read().fields["voltage"], 12.5987, places=4)
+
+    def test_no_divider(self):
+        self.assertAlmostEqual(self.adc(3.7, 1.0).read().fields["voltage"], 3.7)
+
+    def test_full_scale(self):
+        sensor = ADS1115(divider=2.0, simulated=True)
+        self.assertAlmostEqual(sensor.metadata()["fields"]["voltage"]["max"], 8.192)
+
+    def test_read_error(self):
+        class Broken:
+            @property
+            def voltage(self):
+                raise OSError("I2C error")
+        sensor = ADS1115(simulated=True)
+        sensor.channel = Broken()
+        self.assertIsNone(sensor.read())
+        self.assertEqual(sensor.failures, 1)
+
+    def test_simulated(self):
+        sensor = ADS1115(divider=3.0, simulated=True)
+        self.assertAlmostEqual(sensor.read().fields["voltage"], 3.7, delta=0.1)
+
+    def test_factory(self):
+        sensor = create_sensor("ads1115", {"address": "0x49", "channel": 2, "divider": 4.0, "simulated": True})
+        self.assertEqual((sensor.address, sensor.channel_number, sensor.divider), (0x49, 2, 4.0))
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,138 @@
+import json
+import unittest
+from unittest import mock
+from alerts import Threshold
+from clock import FakeClock
+import main
+from fakes import FakeRequest, FakeSensor, run
+from sensors import SensorData
+
+
+class Feed:
+    """Readings for a threshold, `every` seconds apart by a FakeClock."""
+    def __init__(self, threshold: Threshold, every: float = 10):
+        self.threshold = threshold
+        self.every = every
+        self.clock = FakeClock()
+
+    def send(self, *values):
+        alerts = []
+        for value in values:
+            alert = self.threshold.check(SensorData("dht22", {"temperature": value}, timestamp=self.clock.now()))
+            if alert:
+                alerts.append("recovered" if alert.recovered else "fired")
+            self.clock.advance(self.every)
+        return alerts
+
+
+class ThresholdTest(unittest.TestCase):
+    def test_fires_on_crossing_only(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30))
+        self.assertEqual(feed.send(29, 31, 32, 33), ["fired"])
+        self.assertEqual(feed.threshold.state, "alerting")
+
+    def test_oscillating_without_hysteresis_or_dwell_flaps(self):
+        # What the margin and dwell time are for
+        feed = Feed(Threshold("dht22", "temperature", above=30, notify_recovery=True))
+        self.assertEqual(feed.send(29.9, 30.1, 29.9, 30.1), ["fired", "recovered", "fired"])
+
+    def test_hysteresis_absorbs_oscillation(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30, hysteresis=1, notify_recovery=True))
+        self.assertEqual(feed.send(29.5, 30.5, 29.5, 30.5, 29.2, 30.2, 29.5), ["fired"])
+        self.assertEqual(feed.send(28.9), ["recovered"])
+
+    def test_dwell_absorbs_oscillation(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30, dwell_seconds=60, notify_recovery=True))
+        # Over the limit for at most 20s at a time
+        self.assertEqual(feed.send(*[30.5, 30.5, 29.5] * 10), [])
+        self.assertEqual(feed.threshold.state, "ok")
+
+    def test_dwell_fires_once_sustained(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30, dwell_seconds=60))
+        self.assertEqual(feed.send(31, 31, 31, 31, 31, 31), [])
+        self.assertEqual(feed.threshold.state, "pending")
+        self.assertEqual(feed.send(31), ["fired"])
+
+    def test_pending_restarts_after_a_clear_reading(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30, dwell_seconds=30))
+        self.assertEqual(feed.send(31, 31, 29, 31, 31, 31), [])
+        self.assertEqual(feed.send(31), ["fired"])
+
+    def test_recovery_dwell(self):
+        feed = Feed(Threshold("dht22", "temperature", above=30, hysteresis=1, clear_dwell_seconds=30,
+                              notify_recovery=True))
+        self.assertEqual(feed.send(31), ["fired"])
+        # Back in the hysteresis band doesn't count as clear, and going back over sends nothing
+        self.assertEqual(feed.send(28, 28, 29.5, 28, 31, 28, 28), [])
+        self.assertEqual(feed.threshold.state, "recovering")
+        self.assertTrue(feed.threshold.active)
+        # Clear since 60s, so 30s later at 90s
+        self.assertEqual(feed.send(28), [])
+        self.assertEqual(feed.send(28), ["recovered"])
+        self.assertEqual(feed.threshold.state, "ok")
+
+    def test_below_mirrors_above(self):
+        feed = Feed(Threshold("dht22", "temperature", below=5, hysteresis=1, dwell_seconds=20,
+                              notify_recovery=True))
+        self.assertEqual(feed.send(4.5, 5.5, 4.5, 4.8, 4.9), ["fired"])
+        self.assertEqual(feed.send(5.5, 4.9, 5.5, 6.0, 6.1, 6.2), ["recovered"])
+
+    def test_no_alerts_for_other_readings(self):
+        threshold = Threshold("dht22", "temperature", above=30)
+        self.assertIsNone(threshold.check(SensorData("bmp280", {"temperature": 40.0})))
+        self.assertIsNone(threshold.check(SensorData("dht22", {"humidity": 99.0})))
+        self.assertIsNone(threshold.check(SensorData("dht22", {"temperature": "n/a"})))
+
+    def test_validation(self):
+        with self.assertRaises(ValueError):
+            Threshold("dht22", "temperature")
+        with self.assertRaises(ValueError):
+            Threshold("dht22", "temperature", above=30, below=5)
+        with self.assertRaises(ValueError):
+            Threshold("dht22", "temperature", above=30, dwell_seconds=-1)
+
+    def test_numbers_only(self):
+        for options in ({"above": "30"}, {"below": True}, {"above": 30, "hysteresis": "1"},
+                        {"above": 30, "dwell_seconds": [60]}, {"above": 30, "clear_dwell_seconds": "60"}):
+            with self.subTest(options=options), self.assertRaises(ValueError):
+                Threshold("dht22", "temperature", **options)
+        with self.assertRaises(ValueError):
+            Threshold("dht22", ["temperature"], above=30)
+        with self.assertRaises(ValueError):
+            Threshold(None, "temperature", above=30)
+        self.assertEqual(Threshold("dht22", "temperature", above=30, hysteresis=0.5).above, 30)
+
+
+class UpdateThresholdsTest(unittest.TestCase):
+    def setUp(self):
+        self.app = {"sensors": [FakeSensor("DHT22")], "live_config": {}}
+        self.current = [Threshold("dht22", "temperature", above=30)]
+        patch = mock.patch.object(main.alerter, "thresholds", self.current)
+        patch.start()
+        self.addCleanup(patch.stop)
+
+    def put(self, body):
+        request = FakeRequest(self.app, "/api/thresholds", body=body, method="PUT")
+        return run(main.update_thresholds_handler(request))
+
+    def test_string_limit_rejected(self):
+        with mock.patch.object(main, "save_thresholds") as save:
+            request = FakeRequest(self.app, "/api/thresholds", method="PUT",
+                                  body=[{"sensor": "dht22", "field": "temperature", "above": "30"}])
+            request.query = {"persist": "1"}
+            response = run(main.update_thresholds_handler(request))
+        self.assertEqual(response.status, 400)
+        self.assertIn("above must be a number", json.loads(response.text)["error"]["details"][0])
+        self.assertIs(main.alerter.thresholds, self.current)
+        save.assert_not_called()
+        # And readings are still checked against the old one
+        self.assertIsNotNone(main.alerter.thresholds[0].check(SensorData("dht22", {"temperature": 31.0})))
+
+    def test_valid_update(self):
+        response = self.put([{"sensor": "dht22", "field": "temperature", "above": 35}])
+        self.assertEqual(response.status, 200)
+        self.assertEqual([t.above for t in main.alerter.thresholds], [35])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,119 @@
+import asyncio
+import json
+import unittest
+from unittest import mock
+from auth import Chain, Identity, InvalidToken, StaticToken, Tokens
+from clock import FakeClock
+import main
+from fakes import FakeRequest
+
+
+class Directory(StaticToken):
+    """A stand-in AUTH_BACKEND that knows one user, who isn't an admin."""
+    def __init__(self):
+        super().__init__("user-token", name="alice", admin=False)
+
+
+async def ok(request):
+    return main.web.json_response({"identity": request['identity'].name})
+
+
+async def anonymous(request):
+    return main.web.json_response({})
+
+
+def call(handler, request):
+    return asyncio.run(handler(request))
+
+
+def body(response):
+    return json.loads(response.text)
+
+
+class ChainTest(unittest.TestCase):
+    def setUp(self):
+        self.clock = FakeClock()
+        self.tokens = Tokens("secret", ttl=60, clock=self.clock)
+        self.chain = Chain([StaticToken("secret"), self.tokens, Directory()])
+
+    def test_static_token_is_admin(self):
+        identity = self.chain.authenticate("secret")
+        self.assertEqual(identity, Identity("api_token", admin=True))
+
+    def test_backend_identity(self):
+        identity = self.chain.authenticate("user-token")
+        self.assertEqual(identity.name, "alice")
+        self.assertFalse(identity.admin)
+
+    def test_issued_token_expires(self):
+        token, expires_at = self.tokens.issue()
+        self.assertEqual(self.chain.expires(token), expires_at)
+        self.clock.advance(61)
+        with self.assertRaisesRegex(InvalidToken, "expired"):
+            self.chain.authenticate(token)
+
+    def test_unknown_token(self):
+        with self.assertRaisesRegex(InvalidToken, "invalid token"):
+            self.chain.authenticate("nope")
+        self.assertFalse(self.chain.valid(""))
+
+
+class MiddlewareTest(unittest.TestCase):
+    def setUp(self):
+        self.tokens = Tokens("secret", ttl=60, clock=FakeClock())
+        self.app = {"authenticator": Chain([StaticToken("secret"), self.tokens, Directory()])}
+
+    def request(self, path, token=None):
+        return FakeRequest(self.app, path, token)
+
+    def test_api_accepts_any_identity(self):
+        response = call(lambda r: main.auth_middleware(r, ok), self.request("/api/status", "user-token"))
+        self.assertEqual(body(response), {"identity": "alice"})
+
+    def test_missing_token_is_401(self):
+        response = call(lambda r: main.auth_middleware(r, ok), self.request("/api/status"))
+        self.assertEqual(response.status, 401)
+
+    def test_admin_needs_admin_identity(self):
+        response = call(lambda r: main.auth_middleware(r, ok), self.request("/admin/reload", "user-token"))
+        self.assertEqual(response.status, 403)
+        response = call(lambda r: main.auth_middleware(r, ok), self.request("/admin/reload", "secret"))
+        self.assertEqual(response.status, 200)
+
+    def test_admin_without_authenticator_is_403(self):
+        request = FakeRequest({"authenticator": None}, "/admin/reload")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, ok), request).status, 403)
+
+    def test_calibration_needs_admin(self):
+        path = "/api/sensors/dht22/calibrate"
+        request = FakeRequest({"authenticator": None}, path, method="POST")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, ok), request).status, 403)
+        for token, status in (("user-token", 403), ("secret", 200)):
+            request = FakeRequest(self.app, path, token, method="POST")
+            self.assertEqual(call(lambda r: main.auth_middleware(r, ok), request).status, status)
+        # Reading is still open without a token
+        request = FakeRequest({"authenticator": None}, "/api/sensors/dht22", method="GET")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, anonymous), request).status, 200)
+
+    def test_threshold_changes_need_admin(self):
+        request = FakeRequest({"authenticator": None}, "/api/thresholds", method="PUT")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, anonymous), request).status, 403)
+        request = FakeRequest(self.app, "/api/thresholds", "user-token", method="PUT")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, ok), request).status, 403)
+        request = FakeRequest(self.app, "/api/thresholds", "user-token", method="GET")
+        self.assertEqual(call(lambda r: main.auth_middleware(r, ok), request).status, 200)
+
+    def test_only_admins_issue_tokens(self):
+        with mock.patch.object(main, "API_TOKEN", "secret"), mock.patch.object(main, "tokens", self.tokens):
+            for token, status in (("secret", 200), ("user-token", 403), (self.tokens.issue()[0], 403)):
+                with self.subTest(token=token):
+                    response = call(lambda r: main.auth_middleware(r, main.token_handler),
+                                    self.request("/api/token", token))
+                    self.assertEqual(response.status, status)
+            issued = body(call(lambda r: main.auth_middleware(r, main.token_handler),
+                               self.request("/api/token", "secret")))["token"]
+            self.assertEqual(self.tokens.authenticate(issued).name, "issued")
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,139 @@
+import struct
+import unittest
+from sensors import (BMP280, BMP280_CALIBRATION, InsufficientData, _c_div, bmp280_calibration,
+                     bmp280_pressure, bmp280_temperature)
+
+# The worked example in section 3.12 of the datasheet
+DATASHEET = {"T1": 27504, "T2": 26435, "T3": -1000, "P1": 36477, "P2": -10685, "P3": 3024, "P4": 2855,
+             "P5": 140, "P6": -7, "P7": 15500, "P8": -14600, "P9": 6000}
+
+
+def reference(adc_t, adc_p, cal):
+    """The datasheet's double-precision formulas (section 8.1), which have no integer pitfalls."""
+    var1 = (adc_t / 16384 - cal["T1"] / 1024) * cal["T2"]
+    var2 = (adc_t / 131072 - cal["T1"] / 8192) ** 2 * cal["T3"]
+    t_fine = int(var1 + var2)
+    temperature = (var1 + var2) / 5120
+    var1 = t_fine / 2 - 64000
+    var2 = var1 * var1 * cal["P6"] / 32768
+    var2 = var2 + var1 * cal["P5"] * 2
+    var2 = var2 / 4 + cal["P4"] * 65536
+    var1 = (cal["P3"] * var1 * var1 / 524288 + cal["P2"] * var1) / 524288
+    var1 = (1 + var1 / 32768) * cal["P1"]
+    p = 1048576 - adc_p
+    p = (p - var2 / 4096) * 6250 / var1
+    var1 = cal["P9"] * p * p / 2147483648
+    var2 = p * cal["P8"] / 32768
+    return temperature, (p + (var1 + var2 + cal["P7"]) / 16) / 100
+
+
+def adc_for_temperature(target):
+    # Temperature rises with adc_T, so bisect for the reading that gives `target`
+    low, high = 0, (1 << 20) - 1
+    while low < high:
+        middle = (low + high) // 2
+        if bmp280_temperature(middle, DATASHEET)[1] < target:
+            low = middle + 1
+        else:
+            high = middle
+    return low
+
+
+def adc_for_pressure(target, t_fine):
+    # Pressure falls as adc_P rises
+    low, high = 0, (1 << 20) - 1
+    while low < high:
+        middle = (low + high) // 2
+        if bmp280_pressure(middle, t_fine, DATASHEET) > target:
+            low = middle + 1
+        else:
+            high = middle
+    return low
+
+
+class CompensationTest(unittest.TestCase):
+    def test_datasheet_example(self):
+        t_fine, temperature = bmp280_temperature(519888, DATASHEET)
+        self.assertEqual((t_fine, temperature), (128422, 25.08))
+        self.assertAlmostEqual(bmp280_pressure(415148, t_fine, DATASHEET), 1006.5327, places=3)
+
+    def test_t_fine_around_zero(self):
+        adc_t = adc_for_temperature(0)
+        for adc in range(adc_t - 40, adc_t + 40):
+            t_fine, temperature = bmp280_temperature(adc, DATASHEET)
+            expected, _ = reference(adc, 415148, DATASHEET)
+            self.assertAlmostEqual(temperature, expected, delta=0.011)
+            self.assertEqual(temperature < 0, t_fine < -25)
+
+    def test_below_freezing(self):
+        for target in (-40, -20, -5, -0.5):
+            adc_t = adc_for_temperature(target)
+            t_fine, temperature = bmp280_temperature(adc_t, DATASHEET)
+            self.assertLess(t_fine, 0)
+            self.assertAlmostEqual(temperature, target, delta=0.02)
+            adc_p = adc_for_pressure(1013.25, t_fine)
+            self.assertAlmostEqual(bmp280_pressure(adc_p, t_fine, DATASHEET),
+                                   reference(adc_t, adc_p, DATASHEET)[1], delta=0.01)
+
+    def test_low_pressure(self):
+        # 300 hPa is about 9km up, the bottom of the range, and cold
+        for temperature in (-40, -10, 15):
+            adc_t = adc_for_temperature(temperature)
+            t_fine, _ = bmp280_temperature(adc_t, DATASHEET)
+            for pressure in (300, 500, 700):
+                adc_p = adc_for_pressure(pressure, t_fine)
+                compensated = bmp280_pressure(adc_p, t_fine, DATASHEET)
+                self.assertAlmostEqual(compensated, pressure, delta=0.05)
+                self.assertAlmostEqual(compensated, reference(adc_t, adc_p, DATASHEET)[1], delta=0.01)
+
+    def test_full_range_matches_reference(self):
+        for target in range(-40, 86, 25):
+            adc_t = adc_for_temperature(target)
+            t_fine, temperature = bmp280_temperature(adc_t, DATASHEET)
+            for pressure in range(300, 1101, 200):
+                adc_p = adc_for_pressure(pressure, t_fine)
+                expected_t, expected_p = reference(adc_t, adc_p, DATASHEET)
+                self.assertAlmostEqual(temperature, expected_t, delta=0.011)
+                self.assertAlmostEqual(bmp280_pressure(adc_p, t_fine, DATASHEET), expected_p, delta=0.01)
+
+    def test_c_division(self):
+        self.assertEqual(_c_div(-7, 2), -3)
+        self.assertEqual(_c_div(7, -2), -3)
+        self.assertEqual(_c_div(7, 2), 3)
+
+
+class CalibrationTest(unittest.TestCase):
+    def test_signedness(self):
+        block = BMP280_CALIBRATION.pack(*DATASHEET.values())
+        self.assertEqual(bmp280_calibration(block), DATASHEET)
+
+    def test_unsigned_words(self):
+        # Above 0x7FFF, T1 and P1 must stay positive and the rest go negative
+        block = struct.pack("<12H", *[0x9000] * 12)
+        cal = bmp280_calibration(block)
+        self.assertEqual((cal["T1"], cal["P1"]), (0x9000, 0x9000))
+        self.assertTrue(all(cal[name] == 0x9000 - 0x10000 for name in cal if name not in ("T1", "P1")))
+
+
+class ReadTest(unittest.TestCase):
+    def sensor(self):
+        sensor = BMP280(simulation=object())
+        sensor.calibration = DATASHEET
+        return sensor
+
+    def test_registers(self):
+        # press 415148 and temp 519888 left-aligned in 20 bits over msb, lsb, xlsb[7:4]
+        data = bytes([415148 >> 12, (415148 >> 4) & 0xFF, (415148 & 0xF) << 4,
+                      519888 >> 12, (519888 >> 4) & 0xFF, (519888 & 0xF) << 4])
+        fields = self.sensor().compensate(data)
+        self.assertEqual(fields["temperature"], 25.08)
+        self.assertAlmostEqual(fields["pressure"], 1006.53, places=2)
+        self.assertAlmostEqual(fields["altitude"], 56.1, delta=0.2)
+
+    def test_skipped(self):
+        with self.assertRaises(InsufficientData):
+            self.sensor().compensate(bytes([0x80, 0, 0, 0x80, 0, 0]))
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,59 @@
+import unittest
+from fakes import FakeSensor
+from sensors import Calibrate, SoilMoisture, two_point_calibration
+
+
+class CalibrateTest(unittest.TestCase):
+    def test_linear_correction(self):
+        sensor = Calibrate(FakeSensor(readings=[{"temperature": 22.0}]),
+                           {"temperature": {"scale": 1.02, "offset": -2.0}})
+        self.assertAlmostEqual(sensor.read().fields["temperature"], 22.0 * 1.02 - 2.0)
+
+    def test_defaults(self):
+        sensor = Calibrate(FakeSensor(readings=[{"temperature": 22.0}, {"temperature": 22.0}]),
+                           {"temperature": {"offset": -2.0}})
+        self.assertAlmostEqual(sensor.read().fields["temperature"], 20.0)
+        sensor.calibration = {"temperature": {"scale": 2.0}}
+        self.assertAlmostEqual(sensor.read().fields["temperature"], 44.0)
+
+    def test_uncalibrated_fields_untouched(self):
+        reading = {"temperature": 22.0, "humidity": 41.5, "status": "ok"}
+        sensor = Calibrate(FakeSensor(readings=[reading]), {"temperature": {"offset": -2.0}})
+        fields = sensor.read().fields
+        self.assertEqual(fields["humidity"], 41.5)
+        self.assertEqual(fields["status"], "ok")
+
+    def test_non_numeric_untouched(self):
+        sensor = Calibrate(FakeSensor(readings=[{"temperature": "n/a"}]), {"temperature": {"offset": -2.0}})
+        self.assertEqual(sensor.read().fields["temperature"], "n/a")
+
+    def test_no_reading(self):
+        self.assertIsNone(Calibrate(FakeSensor(), {"temperature": {"offset": -2.0}}).read())
+
+
+class TwoPointTest(unittest.TestCase):
+    def test_fit(self):
+        fit = two_point_calibration(10, 0, 20, 100)
+        self.assertAlmostEqual(fit["scale"], 10)
+        self.assertAlmostEqual(fit["offset"], -100)
+
+    def test_equal_readings(self):
+        with self.assertRaises(ValueError):
+            two_point_calibration(10, 0, 10, 100)
+
+
+class SoilMoistureTest(unittest.TestCase):
+    def test_calibrate(self):
+        probe = SoilMoisture(simulated=True)
+        self.assertEqual(probe.calibrate("moisture", [(700, 0), (300, 100)]), {"air": 700, "water": 300})
+        self.assertEqual(probe.transform["raw"], [700, 300])
+
+    def test_equal_truths(self):
+        probe = SoilMoisture(simulated=True)
+        with self.assertRaises(ValueError):
+            probe.calibrate("moisture", [(700, 50), (300, 50)])
+        self.assertEqual(probe.transform["raw"], [800, 400])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,107 @@
+import unittest
+from datetime import datetime, timedelta, timezone
+from aggregate import Aggregator
+from clock import ClockGuard, FakeClock
+from fakes import FakeSensor
+from sensors import ADS1115, Warmup
+
+START = datetime(2025, 6, 1, 12, 0, tzinfo=timezone.utc)
+
+
+class FakeClockTest(unittest.TestCase):
+    def test_advance(self):
+        clock = FakeClock(START)
+        clock.advance(90)
+        self.assertEqual(clock.now(), START + timedelta(seconds=90))
+        self.assertEqual(clock.monotonic(), 90)
+
+    def test_sleep_advances(self):
+        clock = FakeClock(START)
+        clock.sleep(2.5)
+        self.assertEqual(clock.monotonic(), 2.5)
+
+    def test_set_leaves_monotonic(self):
+        clock = FakeClock(START)
+        clock.set(START + timedelta(days=1))
+        self.assertEqual(clock.monotonic(), 0)
+
+
+class TimestampTest(unittest.TestCase):
+    def test_driver_stamps_with_its_clock(self):
+        sensor = ADS1115(simulated=True)
+        sensor.clock = FakeClock(START)
+        self.assertEqual(sensor.read().timestamp, START)
+        sensor.clock.advance(5)
+        self.assertEqual(sensor.read().timestamp, START + timedelta(seconds=5))
+
+    def test_wrappers_share_the_clock(self):
+        clock = FakeClock(START)
+        sensor = Warmup(FakeSensor(clock=clock))
+        self.assertIs(sensor.clock, clock)
+
+
+class WarmupTest(unittest.TestCase):
+    def test_duration(self):
+        clock = FakeClock(START)
+        sensor = Warmup(FakeSensor(readings=[{"temperature": 20.0}] * 3, clock=clock), duration=30)
+        self.assertIsNone(sensor.read())
+        clock.advance(29)
+        self.assertIsNone(sensor.read())
+        clock.advance(1)
+        self.assertEqual(sensor.read().fields, {"temperature": 20.0})
+
+    def test_reads_and_duration(self):
+        clock = FakeClock(START)
+        sensor = Warmup(FakeSensor(readings=[{"temperature": 20.0}] * 3, clock=clock), reads=2, duration=10)
+        clock.advance(10)
+        self.assertIsNone(sensor.read())
+        self.assertTrue(sensor.warming_up)
+        self.assertIsNone(sensor.read())
+        self.assertIsNotNone(sensor.read())
+
+
+class AggregatorWindowTest(unittest.TestCase):
+    def test_window_closes_on_time(self):
+        clock = FakeClock(START)
+        sensor = FakeSensor(readings=[{"temperature": t} for t in (20.0, 22.0, 30.0)], clock=clock)
+        aggregator = Aggregator(60, ["mean", "max"], clock=clock)
+        self.assertIsNone(aggregator.add(sensor.read()))
+        clock.advance(59)
+        self.assertIsNone(aggregator.add(sensor.read()))
+        clock.advance(1)
+        point = aggregator.add(sensor.read())
+        self.assertEqual(point.fields, {"temperature_mean": 21.0, "temperature_max": 22.0})
+        self.assertEqual(point.timestamp, START + timedelta(seconds=59))
+
+
+class ClockGuardTest(unittest.TestCase):
+    def test_plausible(self):
+        clock = FakeClock(datetime(1970, 1, 1, tzinfo=timezone.utc))
+        guard = ClockGuard(clock, min_valid=datetime(2024, 1, 1, tzinfo=timezone.utc))
+        self.assertFalse(guard.plausible())
+        clock.set(START)
+        self.assertTrue(guard.plausible())
+        self.assertTrue(ClockGuard(clock, None).plausible(datetime(1970, 1, 1, tzinfo=timezone.utc)))
+
+    def test_jump(self):
+        clock = FakeClock(datetime(1970, 1, 1, tzinfo=timezone.utc))
+        guard = ClockGuard(clock, None, jump_threshold=60)
+        clock.advance(30)
+        self.assertIsNone(guard.check())
+        clock.set(START)
+        jump = guard.check()
+        self.assertAlmostEqual(jump, (START - datetime(1970, 1, 1, 0, 0, 30, tzinfo=timezone.utc)).total_seconds())
+        self.assertEqual(guard.last_jump, jump)
+
+    def test_restamp(self):
+        clock = FakeClock(datetime(1970, 1, 1, tzinfo=timezone.utc))
+        guard = ClockGuard(clock, None)
+        clock.advance(10)
+        held_at = clock.monotonic()
+        clock.advance(20)
+        clock.set(START)
+        self.assertEqual(guard.restamp(held_at), START - timedelta(seconds=20))
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,63 @@
+import unittest
+from fakes import FakeSensor
+from sensors import Deduplicate
+
+
+def dedup(readings, epsilons, heartbeat=300):
+    sensor = FakeSensor(readings=readings)
+    return Deduplicate(sensor, epsilons, heartbeat), sensor.clock
+
+
+class DeduplicateTest(unittest.TestCase):
+    def test_first_reading_is_reported(self):
+        sensor, _ = dedup([{"pressure": 1013.2}], {"pressure": 0.1})
+        self.assertEqual(sensor.read().fields, {"pressure": 1013.2})
+
+    def test_unchanged_is_suppressed(self):
+        sensor, clock = dedup([{"pressure": 1013.2}, {"pressure": 1013.25}], {"pressure": 0.1})
+        sensor.read()
+        clock.advance(10)
+        self.assertIsNone(sensor.read())
+
+    def test_change_beyond_epsilon(self):
+        sensor, clock = dedup([{"pressure": 1013.2}, {"pressure": 1013.4}], {"pressure": 0.1})
+        sensor.read()
+        clock.advance(10)
+        self.assertEqual(sensor.read().fields, {"pressure": 1013.4})
+
+    def test_compares_with_last_reported(self):
+        # Small steps that add up are reported once they pass epsilon
+        readings = [{"pressure": 1013.0}, {"pressure": 1013.06}, {"pressure": 1013.12}]
+        sensor, clock = dedup(readings, {"pressure": 0.1})
+        sensor.read()
+        clock.advance(10)
+        self.assertIsNone(sensor.read())
+        clock.advance(10)
+        self.assertEqual(sensor.read().fields, {"pressure": 1013.12})
+
+    def test_heartbeat(self):
+        readings = [{"pressure": 1013.2}] * 3
+        sensor, clock = dedup(readings, {"pressure": 0.1}, heartbeat=60)
+        sensor.read()
+        clock.advance(59)
+        self.assertIsNone(sensor.read())
+        clock.advance(1)
+        self.assertEqual(sensor.read().fields, {"pressure": 1013.2})
+
+    def test_only_listed_fields(self):
+        readings = [{"pressure": 1013.2, "temperature": 21.0}] * 2
+        sensor, clock = dedup(readings, {"pressure": 0.1})
+        sensor.read()
+        clock.advance(10)
+        self.assertEqual(sensor.read().fields, {"temperature": 21.0})
+
+    def test_non_numeric(self):
+        readings = [{"state": "ok"}, {"state": "ok"}, {"state": "low"}]
+        sensor, clock = dedup(readings, {"state": 0})
+        sensor.read()
+        self.assertIsNone(sensor.read())
+        self.assertEqual(sensor.read().fields, {"state": "low"})
+
+
+if __name__ == "__main__":
+    unittest.main()
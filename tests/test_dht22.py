@@ -0,0 +1,83 @@
+import unittest
+from sensors import DHT22, ChecksumError, InsufficientData, PulseReplay, Quality
+from retry import RetryPolicy
+
+
+class DHT22ReplayTest(unittest.TestCase):
+    def test_good_frame(self):
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3)]))
+        data = sensor.read()
+        self.assertEqual(data.fields, {"temperature": 22.3, "humidity": 45.6})
+        self.assertFalse(data.quality)
+
+    def test_negative_temperature(self):
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(80.0, -12.5)]))
+        self.assertEqual(sensor.read().fields["temperature"], -12.5)
+
+    def test_corrupt_frame_fails_checksum(self):
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3, corrupt=True)]))
+        with self.assertRaises(ChecksumError):
+            sensor.read()
+
+    def test_corrupt_frame_retried(self):
+        frames = [PulseReplay.frame(45.6, 22.3, corrupt=True), PulseReplay.frame(45.6, 22.3)]
+        sensor = DHT22(retry=RetryPolicy(attempts=2, base_delay=0), replay=PulseReplay(frames))
+        data = sensor.read()
+        self.assertEqual(data.fields["humidity"], 45.6)
+        self.assertIn(Quality.RETRIED, data.quality)
+
+    def test_short_capture(self):
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3)[:40]]))
+        with self.assertRaises(InsufficientData):
+            sensor.read()
+
+    def test_missing_closing_low(self):
+        # The capture stopped right after the last bit
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3)[:-1]]))
+        self.assertEqual(sensor.read().fields, {"temperature": 22.3, "humidity": 45.6})
+
+    def test_truncated_capture(self):
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3)[:-2]]))
+        with self.assertRaisesRegex(InsufficientData, "79 of 80"):
+            sensor.read()
+
+    def test_long_high_before_the_response(self):
+        frame = PulseReplay.frame(45.6, 22.3)
+        frame[0] = 120
+        self.assertEqual(DHT22(replay=PulseReplay([frame])).read().fields["humidity"], 45.6)
+
+    def test_no_response(self):
+        # Only the data, as if the start of the answer was missed
+        sensor = DHT22(replay=PulseReplay([PulseReplay.frame(45.6, 22.3)[3:]]))
+        with self.assertRaisesRegex(InsufficientData, "no DHT22 response"):
+            sensor.read()
+
+    def test_debug_keeps_failed_capture(self):
+        frame = PulseReplay.frame(45.6, 22.3, corrupt=True)
+        sensor = DHT22(debug=True, replay=PulseReplay([frame]))
+        with self.assertRaises(ChecksumError):
+            sensor.read()
+        self.assertEqual(sensor.last_pulses, frame)
+
+    def test_bit_threshold(self):
+        # Stretched 0s read as 1s until the threshold moves above them
+        frame = [45 if width == PulseReplay.ZERO_US else width for width in PulseReplay.frame(45.6, 22.3)]
+        with self.assertRaises(ChecksumError):
+            DHT22(replay=PulseReplay([frame]), bit_threshold_us=40).read()
+        data = DHT22(replay=PulseReplay([frame]), bit_threshold_us=60).read()
+        self.assertEqual(data.fields["temperature"], 22.3)
+
+    def test_start_signal_options_checked(self):
+        replay = PulseReplay([PulseReplay.frame(45.6, 22.3)])
+        with self.assertRaises(ValueError):
+            DHT22(replay=replay, start_low_ms=0.5)
+        with self.assertRaises(ValueError):
+            DHT22(replay=replay, pull="down")
+        with self.assertRaises(ValueError):
+            DHT22(replay=replay, response_timeout_ms=5)
+        sensor = DHT22(replay=replay, start_low_ms=18, start_high_ms=250, pull="off")
+        self.assertEqual(sensor.read().fields["humidity"], 45.6)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,45 @@
+import os
+import subprocess
+import sys
+import unittest
+import main
+
+
+class InfluxV1SettingsTest(unittest.TestCase):
+    def test_retention_policy(self):
+        self.assertEqual(main.influx_v1_settings("pi", "secret", "sensors", "autogen"),
+                         ("pi:secret", "-", "sensors/autogen"))
+
+    def test_default_retention_policy(self):
+        token, org, bucket = main.influx_v1_settings("pi", "secret", "sensors", "")
+        self.assertEqual(bucket, "sensors")
+
+    def test_no_auth(self):
+        token, org, bucket = main.influx_v1_settings("", "", "sensors", "one_week")
+        self.assertEqual((token, bucket), ("", "sensors/one_week"))
+
+
+class InfluxVersionTest(unittest.TestCase):
+    """INFLUX_VERSION is read at import, so each case starts a fresh interpreter."""
+    def settings(self, **env):
+        script = "import main; print(main.INFLUX_TOKEN, main.INFLUX_ORG, main.INFLUX_BUCKET, sep='|')"
+        return subprocess.run([sys.executable, "-c", script], env={**os.environ, **env},
+                              capture_output=True, text=True, timeout=60)
+
+    def test_version_1(self):
+        done = self.settings(INFLUX_VERSION="1", INFLUX_USERNAME="pi", INFLUX_PASSWORD="secret",
+                             INFLUX_DATABASE="sensors", INFLUX_RETENTION_POLICY="one_week")
+        self.assertEqual(done.stdout.strip().splitlines()[-1], "pi:secret|-|sensors/one_week")
+
+    def test_version_2(self):
+        done = self.settings(INFLUX_VERSION="2", INFLUX_TOKEN="abc", INFLUX_ORG="home", INFLUX_BUCKET="sensors")
+        self.assertEqual(done.stdout.strip().splitlines()[-1], "abc|home|sensors")
+
+    def test_unknown_version(self):
+        done = self.settings(INFLUX_VERSION="3")
+        self.assertNotEqual(done.returncode, 0)
+        self.assertIn("INFLUX_VERSION must be 1 or 2", done.stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
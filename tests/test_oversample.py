@@ -0,0 +1,98 @@
+import unittest
+from datetime import timedelta
+from fakes import FakeSensor
+from sensors import ChecksumError, Oversample, Quality, reject_outliers
+
+
+class SlowSensor(FakeSensor):
+    """Needs 2s between reads, like the DHT22; times every read."""
+    MIN_INTERVAL = 2.0
+
+    def __init__(self, **options):
+        super().__init__(**options)
+        self.read_at = []
+
+    def read(self):
+        self.read_at.append(self.clock.monotonic())
+        return super().read()
+
+
+class FailingSensor(FakeSensor):
+    """Fails every read once its readings run out."""
+    def read(self):
+        if not self.readings:
+            raise ChecksumError("checksum mismatch")
+        return super().read()
+
+
+class RejectOutliersTest(unittest.TestCase):
+    def test_spike(self):
+        self.assertEqual(reject_outliers([21.0, 21.2, 20.9, 35.0, 21.1], 3.0), [21.0, 21.2, 20.9, 21.1])
+
+    def test_too_few_to_judge(self):
+        self.assertEqual(reject_outliers([21.0, 35.0], 3.0), [21.0, 35.0])
+
+    def test_identical(self):
+        self.assertEqual(reject_outliers([21.0, 21.0, 21.0, 30.0], 3.0), [21.0, 21.0, 21.0])
+
+
+class OversampleTest(unittest.TestCase):
+    def test_noisy_average(self):
+        noisy = [20.8, 21.3, 21.0, 48.0, 20.9]
+        sensor = Oversample(FakeSensor(readings=[{"temperature": t, "status": "ok"} for t in noisy]), 5)
+        reading = sensor.read()
+        # The 48.0 spike is dropped, the rest averaged
+        self.assertAlmostEqual(reading.fields["temperature"], 21.0)
+        self.assertEqual(reading.fields["status"], "ok")
+        self.assertEqual(reading.quality, Quality.SMOOTHED)
+
+    def test_int_fields_stay_ints(self):
+        samples = [{"raw": raw, "voltage": raw * 3.3 / 1023} for raw in (512, 515, 513, 900)]
+        reading = Oversample(FakeSensor(readings=samples), 4).read()
+        self.assertEqual(reading.fields["raw"], 513)
+        self.assertIsInstance(reading.fields["raw"], int)
+        self.assertIsInstance(reading.fields["voltage"], float)
+
+    def test_bools_take_the_last_sample(self):
+        samples = [{"motion": value} for value in (True, True, False)]
+        self.assertIs(Oversample(FakeSensor(readings=samples), 3).read().fields["motion"], False)
+
+    def test_single_sample(self):
+        sensor = Oversample(FakeSensor(readings=[{"temperature": 21.0}]), 1)
+        reading = sensor.read()
+        self.assertEqual(reading.fields["temperature"], 21.0)
+        self.assertEqual(reading.quality, Quality.OK)
+
+    def test_respects_min_interval(self):
+        inner = SlowSensor(readings=[{"temperature": 21.0}] * 3)
+        sensor = Oversample(inner, 3, spacing=0.5)
+        self.assertEqual(sensor.spacing, 2.0)
+        self.assertEqual(sensor.span, 4.0)
+        sensor.read()
+        self.assertEqual(inner.read_at, [0.0, 2.0, 4.0])
+
+    def test_longer_spacing(self):
+        inner = SlowSensor(readings=[{"temperature": 21.0}] * 2)
+        Oversample(inner, 2, spacing=5).read()
+        self.assertEqual(inner.read_at, [0.0, 5.0])
+
+    def test_stamped_in_the_middle(self):
+        inner = SlowSensor(readings=[{"temperature": 21.0}] * 3)
+        start = inner.clock.now()
+        self.assertEqual(Oversample(inner, 3).read().timestamp, start + timedelta(seconds=2))
+
+    def test_failed_samples_skipped(self):
+        inner = FailingSensor(readings=[{"temperature": 21.0}, {"temperature": 22.0}])
+        self.assertAlmostEqual(Oversample(inner, 3).read().fields["temperature"], 21.5)
+
+    def test_all_samples_fail(self):
+        with self.assertRaises(ChecksumError):
+            Oversample(FailingSensor(), 3).read()
+
+    def test_needs_a_sample(self):
+        with self.assertRaises(ValueError):
+            Oversample(FakeSensor(), 0)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,65 @@
+import unittest
+from unittest import mock
+from datetime import datetime, timezone
+from pipeline import FunctionStage, Pipeline
+from sensors import SensorData
+from fakes import FakeSensor
+
+
+def append(name):
+    def stage(sensor, data):
+        data.fields.setdefault("seen", "")
+        data.fields["seen"] += name
+        return data
+    return FunctionStage(name, stage)
+
+
+STAGES = {name: append(name) for name in "abc"}
+
+
+def reading():
+    return SensorData("fake", {}, timestamp=datetime(2025, 1, 1, tzinfo=timezone.utc))
+
+
+class PipelineTest(unittest.TestCase):
+    def test_runs_in_configured_order(self):
+        pipeline = Pipeline.from_names(["c", "a"], STAGES)
+        self.assertEqual(pipeline.names(), ["c", "a"])
+        self.assertEqual(pipeline.run(FakeSensor(), reading()).fields["seen"], "ca")
+
+    def test_none_drops_and_stops(self):
+        stages = {**STAGES, "drop": FunctionStage("drop", lambda sensor, data: None)}
+        pipeline = Pipeline.from_names(["a", "drop", "b"], stages)
+        data = reading()
+        self.assertIsNone(pipeline.run(FakeSensor(), data))
+        self.assertEqual(data.fields["seen"], "a")
+
+    def test_unknown_stage(self):
+        with self.assertRaisesRegex(ValueError, "unknown stage.*smooth"):
+            Pipeline.from_names(["a", "smooth"], STAGES)
+
+    def test_stage_listed_twice(self):
+        with self.assertRaisesRegex(ValueError, "twice: a"):
+            Pipeline.from_names(["a", "b", "a"], STAGES)
+
+
+class IntakeTest(unittest.TestCase):
+    def test_default_order(self):
+        import main
+        self.assertEqual(list(main.INTAKE), main.INTAKE_STAGES)
+        self.assertEqual(main.storage_path.names(), ["units", "round"])
+
+    def test_finite_then_range(self):
+        import main
+        sensor = FakeSensor()
+        pipeline = Pipeline.from_names(["finite", "range"], main.INTAKE)
+        data = SensorData("fake", {"temperature": float("nan"), "humidity": 40.0},
+                          timestamp=datetime(2025, 1, 1, tzinfo=timezone.utc))
+        self.assertEqual(pipeline.run(sensor, data).fields, {"humidity": 40.0})
+        with mock.patch.dict(main.range_policies, {"fake": {"*": "drop"}}):
+            data = SensorData("fake", {"temperature": 120.0}, timestamp=datetime(2025, 1, 1, tzinfo=timezone.utc))
+            self.assertIsNone(pipeline.run(sensor, data))
+
+
+if __name__ == "__main__":
+    unittest.main()
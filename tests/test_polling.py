@@ -0,0 +1,229 @@
+import asyncio
+import threading
+import time
+import unittest
+from unittest import mock
+import main
+from fakes import FakeRequest, FakeSensor, run, until
+from sensors import ChecksumError
+
+
+async def ticks(n):
+    for _ in range(n):
+        yield
+
+
+class BrokenSensor(FakeSensor):
+    """A driver with a bug: every read raises something that isn't a SensorError."""
+    def read(self):
+        raise KeyError("calibration")
+
+
+async def poll(*sensors, n=1):
+    await asyncio.gather(*(main.poll_sensor(sensor, 1.0, ticks(n)) for sensor in sensors))
+
+
+class PollTest(unittest.TestCase):
+    def setUp(self):
+        main.read_errors.clear()
+        main.reads_in_flight.clear()
+        patch = mock.patch.object(main, "handle_reading", mock.AsyncMock())
+        self.handle_reading = patch.start()
+        self.addCleanup(patch.stop)
+
+    def test_driver_bug_is_counted(self):
+        broken = BrokenSensor("broken")
+        with self.assertLogs(main.logger, "ERROR") as logs:
+            run(poll(broken, n=3))
+        self.assertEqual(main.read_errors["broken"]["read_errors"], 3)
+        self.assertEqual(main.read_errors["broken"]["last_error"], "KeyError('calibration')")
+        # With the traceback, as nothing expected this
+        self.assertIn("Traceback", logs.output[0])
+
+    def test_other_sensors_keep_reading(self):
+        good = FakeSensor("good", readings=[{"temperature": 21.0}] * 3)
+        with self.assertLogs(main.logger, "ERROR"):
+            run(poll(BrokenSensor("broken"), good, n=3))
+        self.assertEqual(self.handle_reading.await_count, 3)
+        self.assertNotIn("good", main.read_errors)
+
+    def test_handling_bug_is_counted(self):
+        self.handle_reading.side_effect = ZeroDivisionError("division by zero")
+        sensor = FakeSensor("fake", readings=[{"temperature": 21.0}] * 2)
+        with self.assertLogs(main.logger, "ERROR"):
+            run(poll(sensor, n=2))
+        self.assertEqual(main.read_errors["fake"]["read_errors"], 2)
+
+    def test_sensor_error_without_traceback(self):
+        class Failing(FakeSensor):
+            def read(self):
+                raise ChecksumError("checksum mismatch")
+        with self.assertLogs(main.logger, "ERROR") as logs:
+            run(poll(Failing("failing")))
+        self.assertEqual(main.read_errors["failing"]["read_errors"], 1)
+        self.assertNotIn("Traceback", logs.output[0])
+
+
+class ManualTicks:
+    """Ticks for poll_sensor that only come when the test calls tick()."""
+    def __init__(self):
+        self.queue = asyncio.Queue()
+
+    def tick(self):
+        self.queue.put_nowait(None)
+
+    def __aiter__(self):
+        return self
+
+    async def __anext__(self):
+        if await self.queue.get() is StopAsyncIteration:
+            raise StopAsyncIteration
+
+    def stop(self):
+        self.queue.put_nowait(StopAsyncIteration)
+
+
+class TickTest(unittest.TestCase):
+    def setUp(self):
+        main.reads_in_flight.clear()
+        for name, target in (("write", "write_to_sinks"), ("broadcast", "broadcast_to_clients")):
+            patch = mock.patch.object(main, target, mock.AsyncMock())
+            setattr(self, name, patch.start())
+            self.addCleanup(patch.stop)
+
+    def writes(self):
+        return [data.sensor_type for data in (call.args[0] for call in self.write.await_args_list)]
+
+    def broadcasts(self):
+        return [data.sensor_type for data in (call.args[0] for call in self.broadcast.await_args_list)]
+
+    def test_one_write_and_broadcast_per_sensor_per_tick(self):
+        sensors = [FakeSensor(name, readings=[{"temperature": 20.0 + i} for i in range(3)])
+                   for name in ("first", "second")]
+        ticks = {sensor.name(): ManualTicks() for sensor in sensors}
+
+        async def scenario():
+            polls = [asyncio.create_task(main.poll_sensor(sensor, 1.0, ticks[sensor.name()])) for sensor in sensors]
+            await asyncio.sleep(0.01)
+            self.assertEqual(self.writes(), [])
+            for n in (1, 2):
+                for sensor_ticks in ticks.values():
+                    sensor_ticks.tick()
+                await until(lambda: len(self.broadcasts()) == 2 * n)
+                await asyncio.sleep(0.01)
+                self.assertEqual(sorted(self.writes()), sorted(["first", "second"] * n))
+                self.assertEqual(sorted(self.writes()), sorted(self.broadcasts()))
+            for sensor_ticks in ticks.values():
+                sensor_ticks.stop()
+            await asyncio.gather(*polls)
+
+        run(scenario())
+        # One reading left each: nothing read without a tick
+        self.assertEqual([len(sensor.readings) for sensor in sensors], [1, 1])
+
+    def test_one_sensor_ticking(self):
+        sensors = [FakeSensor(name, readings=[{"temperature": 20.0}]) for name in ("first", "second")]
+        ticks = ManualTicks()
+
+        async def scenario():
+            polls = [asyncio.create_task(main.poll_sensor(sensors[0], 1.0, ticks)),
+                     asyncio.create_task(main.poll_sensor(sensors[1], 1.0, ManualTicks()))]
+            ticks.tick()
+            await until(lambda: self.broadcasts())
+            await asyncio.sleep(0.01)
+            for poll in polls:
+                poll.cancel()
+            await asyncio.gather(*polls, return_exceptions=True)
+
+        run(scenario())
+        self.assertEqual((self.writes(), self.broadcasts()), (["first"], ["first"]))
+
+
+class SlowSensor(FakeSensor):
+    """Takes 50ms per read and counts how many reads overlap."""
+    def __init__(self, name="slow"):
+        super().__init__(name)
+        self.lock = threading.Lock()
+        self.active = 0
+        self.most_active = 0
+        self.reads = 0
+
+    def read(self):
+        with self.lock:
+            self.active += 1
+            self.reads += 1
+            self.most_active = max(self.most_active, self.active)
+            n = self.reads
+        time.sleep(0.05)
+        with self.lock:
+            self.active -= 1
+        return main.SensorData(self._name, {"temperature": float(n)}, timestamp=self.clock.now())
+
+
+class SingleFlightTest(unittest.TestCase):
+    def setUp(self):
+        main.reads_in_flight.clear()
+
+    def test_concurrent_callers_share_one_read(self):
+        sensor = SlowSensor()
+
+        async def hammer():
+            reads = [main.start_read(sensor) for _ in range(20)]
+            self.assertEqual([started for _, started in reads], [True] + [False] * 19)
+            return await asyncio.gather(*(in_flight for in_flight, _ in reads))
+
+        results = run(hammer())
+        self.assertEqual(sensor.reads, 1)
+        self.assertEqual({data.fields["temperature"] for data in results}, {1.0})
+
+    def test_reads_never_overlap(self):
+        sensor = SlowSensor()
+
+        async def hammer():
+            async def caller(delay):
+                await asyncio.sleep(delay)
+                in_flight, _ = main.start_read(sensor)
+                return await in_flight
+            return await asyncio.gather(*(caller(i * 0.01) for i in range(30)))
+
+        results = run(hammer())
+        self.assertEqual(sensor.most_active, 1)
+        # Each caller got the read that was in flight when it asked, so several reads but far fewer than callers
+        self.assertLess(sensor.reads, 30)
+        self.assertEqual(sorted({data.fields["temperature"] for data in results}),
+                         [float(n) for n in range(1, sensor.reads + 1)])
+
+    def test_on_demand_read_joins_the_scheduled_one(self):
+        sensor = SlowSensor()
+        app = {"draining": False, "sensors": [sensor], "schedule": {sensor.name(): (10, 1.0)}}
+        main.last_read_at.pop(sensor.name(), None)
+
+        async def scenario():
+            with mock.patch.object(main, "handle_reading", mock.AsyncMock()) as handle_reading:
+                poll = asyncio.create_task(main.poll_sensor(sensor, 1.0, ticks(1)))
+                await until(lambda: sensor.reads)
+                with mock.patch.object(main, "last_read_at", {}):
+                    response = await main.read_now_handler(FakeRequest(app, "/api/sensors/slow/read",
+                                                                      match_info={"type": "slow"}))
+                await poll
+            return response, handle_reading
+
+        response, handle_reading = run(scenario())
+        self.assertEqual(sensor.reads, 1)
+        self.assertEqual(response.status, 200)
+        self.assertEqual(handle_reading.await_count, 1)
+
+    def test_sensors_read_in_parallel(self):
+        sensors = [SlowSensor(f"slow{i}") for i in range(4)]
+
+        async def hammer():
+            started = time.monotonic()
+            await asyncio.gather(*(main.start_read(sensor)[0] for sensor in sensors))
+            return time.monotonic() - started
+
+        self.assertLess(run(hammer()), 0.15)
+        self.assertEqual([sensor.reads for sensor in sensors], [1] * 4)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,48 @@
+import unittest
+from datetime import datetime, timezone
+from actuators import SimulatedActuator
+from clock import FakeClock
+from rules import RuleEngine, load_rules
+from sensors import SensorData
+
+
+def reading(temperature):
+    return SensorData("dht22", {"temperature": temperature}, timestamp=datetime(2025, 1, 1, tzinfo=timezone.utc))
+
+
+class LoadRulesTest(unittest.TestCase):
+    def setUp(self):
+        self.fan = SimulatedActuator("fan")
+        self.fan.clock = FakeClock()
+
+    def test_bad_rules_are_skipped(self):
+        rules, errors = load_rules([
+            {"sensor": "dht22", "field": "temperature", "above": 26, "actuator": "fan"},
+            {"sensor": "dht22", "field": "temperature", "above": 26, "actuator": "pump"},
+            {"sensor": "dht22", "field": "temperature", "actuator": "fan"},
+            {"sensor": "dht22", "field": "temperature", "above": 26, "actuator": "fan", "hysteresys": 1},
+            "fan on",
+        ], {"fan": self.fan})
+        self.assertEqual(len(rules), 1)
+        self.assertEqual([error.split(":")[0] for error in errors], ["rule 1", "rule 2", "rule 3", "rule 4"])
+        self.assertIn("pump", errors[0])
+
+    def test_hysteresis_and_dwell(self):
+        rules, _ = load_rules([{"sensor": "dht22", "field": "temperature", "above": 26, "hysteresis": 1,
+                                "min_on_seconds": 60, "actuator": "fan"}], {"fan": self.fan})
+        engine = RuleEngine(rules)
+        engine.evaluate(reading(26.5))
+        self.assertTrue(self.fan.on)
+        # Within the hysteresis band: stays on
+        engine.evaluate(reading(25.5))
+        self.assertTrue(self.fan.on)
+        # Below it, but not on for min_on_seconds yet
+        engine.evaluate(reading(24.0))
+        self.assertTrue(self.fan.on)
+        self.fan.clock.advance(60)
+        engine.evaluate(reading(24.0))
+        self.assertFalse(self.fan.on)
+
+
+if __name__ == "__main__":
+    unittest.main()
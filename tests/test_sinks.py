@@ -0,0 +1,277 @@
+import json
+import os
+import tempfile
+import threading
+import time
+import unittest
+from datetime import datetime, timezone
+from types import SimpleNamespace
+from unittest import mock
+from urllib3.exceptions import MaxRetryError, ReadTimeoutError
+from fakes import FakeRequest, run
+import main
+import sinks
+from sensors import SensorData
+from clock import FakeClock
+from sinks import FieldFilter, FileSink, InfluxSink, InfluxTimeout, Sink, SinkWriter, TimescaleSink
+
+
+def reading(**fields):
+    return SensorData("dht22", fields, timestamp=datetime(2025, 1, 1, tzinfo=timezone.utc))
+
+
+class FieldFilterTest(unittest.TestCase):
+    def test_include_keeps_aggregates(self):
+        data = reading(temperature_mean=21.0, temperature_min=20.0, temperature_max=22.0, humidity_mean=40.0)
+        kept = FieldFilter(include=["temperature"]).apply(data)
+        self.assertEqual(set(kept.fields), {"temperature_mean", "temperature_min", "temperature_max"})
+
+    def test_include_keeps_summary_times(self):
+        data = reading(temperature_min=20.0, temperature_min_at="2025-01-01T03:00:00+00:00", humidity_min=30.0)
+        kept = FieldFilter(include=["temperature"]).apply(data)
+        self.assertEqual(set(kept.fields), {"temperature_min", "temperature_min_at"})
+
+    def test_exclude_drops_aggregates(self):
+        data = reading(temperature=21.0, temperature_mean=21.0, humidity=40.0)
+        kept = FieldFilter(exclude=["temperature"]).apply(data)
+        self.assertEqual(set(kept.fields), {"humidity"})
+
+    def test_own_name_wins(self):
+        data = reading(temperature_mean=21.0, temperature_max=22.0)
+        kept = FieldFilter(include=["temperature"], exclude=["temperature_max"]).apply(data)
+        self.assertEqual(set(kept.fields), {"temperature_mean"})
+        kept = FieldFilter(include=["temperature_max"], exclude=["temperature"]).apply(data)
+        self.assertEqual(set(kept.fields), {"temperature_max"})
+
+    def test_nothing_left(self):
+        self.assertIsNone(FieldFilter(include=["pressure"]).apply(reading(temperature=21.0)))
+
+    def test_no_filter_is_identity(self):
+        data = reading(temperature=21.0)
+        self.assertIs(FieldFilter().apply(data), data)
+
+
+class Hung:
+    """An InfluxDB call that blocks until the client's deadline and times out as urllib3 does."""
+    def __init__(self, sink, wrapped=False):
+        self.sink = sink
+        self.wrapped = wrapped
+        self.calls = 0
+
+    def __call__(self, *args, **kwargs):
+        self.calls += 1
+        time.sleep(self.sink.timeout)
+        error = ReadTimeoutError(None, self.sink.url, f"Read timed out. (read timeout={self.sink.timeout})")
+        raise MaxRetryError(None, self.sink.url, error) if self.wrapped else error
+
+
+class InfluxTimeoutTest(unittest.TestCase):
+    def sink(self):
+        sink = InfluxSink("http://influx:8086", "token", "home", "sensors", timeout=0.05)
+        sink.client = SimpleNamespace(api_client=None)
+        sink.write_api = SimpleNamespace(write=Hung(sink))
+        sink.healthy = True
+        # The real Point needs the real client library
+        sink._point = lambda data: data
+        return sink
+
+    def test_client_gets_the_timeout(self):
+        sink = InfluxSink("http://influx:8086", "token", "home", "sensors", timeout=2.5)
+        with mock.patch.object(sinks, "InfluxDBClient") as client, mock.patch.object(sink, "_ping"):
+            sink._connect()
+        self.assertEqual(client.call_args.kwargs["timeout"], 2500)
+
+    def test_write_times_out(self):
+        sink = self.sink()
+        started = time.monotonic()
+        with self.assertLogs(sinks.logger, "ERROR"):
+            sink.write(reading(temperature=21.0))
+        self.assertLess(time.monotonic() - started, 1)
+        self.assertTrue(sink.timed_out)
+        self.assertFalse(sink.healthy)
+        self.assertIn("within 0.05s", sink.last_error)
+        # Held for when InfluxDB answers again
+        self.assertEqual(sink.backlog_size(), 1)
+
+    def test_timeout_is_its_own_error(self):
+        sink = self.sink()
+        sink.write_api.write.wrapped = True
+        with self.assertRaises(InfluxTimeout):
+            sink.write_batch([reading(temperature=21.0)])
+
+    def test_other_errors_are_not_timeouts(self):
+        sink = self.sink()
+        sink.write_api.write = mock.Mock(side_effect=ConnectionRefusedError("refused"))
+        with self.assertLogs(sinks.logger, "ERROR"):
+            sink.write(reading(temperature=21.0))
+        self.assertFalse(sink.timed_out)
+        self.assertEqual(sink.last_error, "refused")
+
+    def test_health_check_times_out_and_recovers(self):
+        sink = self.sink()
+        hung = Hung(sink, wrapped=True)
+        with mock.patch.object(sinks, "PingService", lambda api_client: SimpleNamespace(get_ping=hung)), \
+                self.assertLogs(sinks.logger, "ERROR"):
+            sink.check_health()
+        self.assertEqual(hung.calls, 1)
+        self.assertEqual((sink.healthy, sink.timed_out), (False, True))
+        with mock.patch.object(sinks, "PingService", lambda api_client: SimpleNamespace(get_ping=lambda: None)):
+            sink.check_health()
+        self.assertEqual((sink.healthy, sink.timed_out, sink.last_error), (True, False, None))
+
+    def test_readyz_reports_the_timeout(self):
+        sink = self.sink()
+        with self.assertLogs(sinks.logger, "ERROR"):
+            sink.write(reading(temperature=21.0))
+        with mock.patch.object(main, "influx_sink", sink):
+            response = run(main.readyz_handler(FakeRequest({"draining": False}, "/readyz")))
+        self.assertEqual(response.status, 503)
+        self.assertEqual(json.loads(response.text)["influx"], "timeout")
+
+
+class SpillTest(unittest.TestCase):
+    """InfluxDB down at shutdown: what it didn't take is saved, and back in the backlog after a restart."""
+    def setUp(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        self.spill = os.path.join(directory.name, "spill.json")
+        for name, value in (("SPILL_FILE", self.spill), ("LATEST_CACHE_FILE", ""), ("writers", [])):
+            patch = mock.patch.object(main, name, value)
+            patch.start()
+            self.addCleanup(patch.stop)
+
+    def influx(self):
+        # Never connected, as when InfluxDB is down at startup and stays down
+        return InfluxSink("http://influx:8086", "token", "home", "sensors")
+
+    def restart(self, *sinks):
+        with mock.patch.object(main, "sinks", list(sinks)):
+            main.restore_unsent()
+
+    def test_influx_down_at_shutdown(self):
+        down = self.influx()
+        readings = [reading(temperature=20.0 + i) for i in range(3)]
+        for data in readings:
+            down.write(data)
+        with mock.patch.object(main, "sinks", [down]), self.assertLogs(main.logger, "INFO"):
+            run(main.cleanup({}))
+        with open(self.spill) as f:
+            self.assertEqual(len(json.load(f)["influxdb"]), 3)
+
+        restarted = self.influx()
+        self.restart(restarted)
+        self.assertEqual([data.to_dict() for data in restarted.backlog], [data.to_dict() for data in readings])
+        # Loaded once: a second restart doesn't replay them again
+        self.assertFalse(os.path.exists(self.spill))
+
+        # Written with the first write once InfluxDB is back
+        restarted.write_api = mock.Mock()
+        restarted._point = lambda data: data.fields["temperature"]
+        restarted.write(reading(temperature=30.0))
+        records = [call.kwargs["record"] for call in restarted.write_api.write.call_args_list]
+        self.assertEqual(records, [30.0, [20.0, 21.0, 22.0]])
+        self.assertEqual(restarted.backlog_size(), 0)
+
+    def test_nothing_unsent(self):
+        with mock.patch.object(main, "sinks", [self.influx()]):
+            main.spill_unsent()
+        self.assertFalse(os.path.exists(self.spill))
+
+    def test_timescale_rows(self):
+        down = TimescaleSink("postgresql://nowhere", batch_size=100, flush_interval=3600)
+        down.write(reading(temperature=21.0, status="ok"))
+        with mock.patch.object(main, "sinks", [down]):
+            main.spill_unsent()
+        restarted = TimescaleSink("postgresql://nowhere")
+        self.restart(restarted)
+        self.assertEqual(restarted.pending, down.pending)
+
+    def test_sink_no_longer_configured(self):
+        down = self.influx()
+        down.write(reading(temperature=21.0))
+        with mock.patch.object(main, "sinks", [down]):
+            main.spill_unsent()
+        with self.assertLogs(main.logger, "WARNING") as logs:
+            self.restart()
+        self.assertIn("influxdb, which isn't configured", logs.output[0])
+
+    def test_corrupt_spill_is_ignored(self):
+        with open(self.spill, "w") as f:
+            f.write("{not json")
+        restarted = self.influx()
+        with self.assertLogs(main.logger, "ERROR"):
+            self.restart(restarted)
+        self.assertEqual(restarted.backlog_size(), 0)
+
+
+
+class FileSinkTest(unittest.TestCase):
+    def setUp(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        self.path = os.path.join(directory.name, "readings.ndjson")
+        self.clock = FakeClock()
+
+    def test_rotates_by_age(self):
+        sink = FileSink(self.path, max_bytes=0, rotate_seconds=3600, clock=self.clock)
+        sink.write(reading(temperature=21.0))
+        self.clock.advance(3599)
+        sink.write(reading(temperature=21.5))
+        self.clock.advance(1)
+        sink.write(reading(temperature=22.0))
+        sink.close()
+        suffix = self.clock.now().astimezone().strftime('%Y%m%dT%H%M%S')
+        with open(f"{self.path}.{suffix}") as f:
+            self.assertEqual([json.loads(line)["fields"]["temperature"] for line in f], [21.0, 21.5, 22.0])
+        self.assertFalse(os.path.exists(self.path))
+
+    def test_flushes_on_the_interval(self):
+        # Without a size limit, which checks the size and so flushes every write
+        sink = FileSink(self.path, max_bytes=0, flush_interval=5, clock=self.clock)
+        self.addCleanup(sink.close)
+        sink.write(reading(temperature=21.0))
+        self.assertEqual(os.path.getsize(self.path), 0)
+        self.clock.advance(5)
+        sink.write(reading(temperature=21.5))
+        with open(self.path) as f:
+            self.assertEqual(len(f.readlines()), 2)
+
+class Interleaved(Sink):
+    """Two writes at once: the failing one sets last_error while the other is still running."""
+    concurrent = True
+
+    def __init__(self):
+        self.both_writing = threading.Barrier(2, timeout=5)
+        self.failed = threading.Event()
+
+    def name(self) -> str:
+        return "interleaved"
+
+    def write(self, data):
+        self.both_writing.wait()
+        if data.fields.get("status") == "bad":
+            self.last_error = "refused"
+            self.failed.set()
+            return "refused"
+        self.failed.wait(5)
+        return None
+
+
+class SinkWriterTest(unittest.TestCase):
+    def test_concurrent_workers_keep_their_own_results(self):
+        writer = SinkWriter(Interleaved(), workers=2)
+
+        async def scenario():
+            writer.start()
+            writer.put(reading(status="bad"))
+            writer.put(reading(status="good"))
+            await writer.drain()
+            await writer.stop()
+
+        with self.assertLogs(sinks.logger, "ERROR"):
+            run(scenario())
+        self.assertEqual((writer.written, writer.failed), (1, 1))
+        self.assertEqual(writer.last_error, "refused")
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,51 @@
+import asyncio
+import os
+import unittest
+import uuid
+from datetime import datetime, timezone
+from sensors import SensorData
+from sinks import TimescaleSink
+
+# A database the tests may create and drop tables in, e.g. postgresql://postgres@localhost/test
+DSN = os.getenv("TIMESCALE_TEST_DSN", "")
+
+
+@unittest.skipUnless(DSN, "set TIMESCALE_TEST_DSN to run against a TimescaleDB")
+class TimescaleSinkTest(unittest.TestCase):
+    def setUp(self):
+        self.sink = TimescaleSink(DSN, table=f"test_{uuid.uuid4().hex[:8]}", batch_size=10)
+
+    def tearDown(self):
+        conn = self.sink._connect()
+        with conn, conn.cursor() as cur:
+            cur.execute(f"DROP TABLE IF EXISTS {self.sink.table}")
+        self.sink.close()
+
+    def test_schema_and_write(self):
+        # As init_app does it: off the event loop
+        asyncio.run(asyncio.to_thread(self.sink.create_schema))
+        at = datetime(2025, 1, 1, tzinfo=timezone.utc)
+        self.sink.write(SensorData("dht22", {"temperature": 21.5, "status": "ok"}, timestamp=at,
+                                   tags={"device": "test"}, seq=7))
+        self.sink.flush()
+        self.assertEqual(self.sink.pending, [])
+        self.assertIsNone(self.sink.last_error)
+        conn = self.sink._connect()
+        with conn, conn.cursor() as cur:
+            cur.execute(f"SELECT time, sensor, field, value, value_text, tags FROM {self.sink.table} ORDER BY field")
+            rows = cur.fetchall()
+        self.assertEqual([row[1:5] for row in rows],
+                         [("dht22", "status", None, "ok"), ("dht22", "temperature", 21.5, None)])
+        self.assertEqual(rows[0][0], at)
+        self.assertEqual(rows[0][5], {"device": "test", "seq": 7})
+
+    def test_unreachable_keeps_rows(self):
+        sink = TimescaleSink("postgresql://nobody@127.0.0.1:1/none", timeout=1)
+        sink.write(SensorData("dht22", {"temperature": 21.5}, timestamp=datetime.now(timezone.utc)))
+        sink.flush()
+        self.assertEqual(len(sink.pending), 1)
+        self.assertIsNotNone(sink.last_error)
+
+
+if __name__ == "__main__":
+    unittest.main()
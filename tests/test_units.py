@@ -0,0 +1,48 @@
+import unittest
+from unittest import mock
+import main
+import units
+from fakes import FakeSensor, run
+from pipeline import FunctionStage, Pipeline
+
+
+class ConvertTest(unittest.TestCase):
+    def test_temperature(self):
+        self.assertAlmostEqual(units.convert(100, "°C", "F"), 212)
+        self.assertAlmostEqual(units.convert(0, "C", "K"), 273.15)
+        self.assertAlmostEqual(units.convert(32, "°F", "C"), 0)
+
+    def test_pressure(self):
+        self.assertAlmostEqual(units.convert(1013.25, "hPa", "inHg"), 29.92, places=2)
+        self.assertAlmostEqual(units.convert(1013.25, "hPa", "mmHg"), 760.0, places=1)
+        self.assertAlmostEqual(units.convert(101.325, "kPa", "hPa"), 1013.25)
+
+    def test_across_dimensions(self):
+        with self.assertRaises(ValueError):
+            units.convert(20, "C", "hPa")
+
+    def test_display(self):
+        self.assertEqual(units.dimension("°F"), "temperature")
+        self.assertEqual(units.display("C"), "°C")
+
+
+class NativeUnitsTest(unittest.TestCase):
+    def test_alerts_and_rules_see_native_units(self):
+        sensor = FakeSensor()
+        fahrenheit = Pipeline([
+            FunctionStage("units", lambda s, data: main.convert_units(s, data, {"temperature": "F"})),
+        ])
+        data = main.SensorData("fake", {"temperature": 30.0}, timestamp=sensor.clock.now())
+        with mock.patch.object(main, "storage_path", fahrenheit), \
+                mock.patch.object(main.alerter, "check") as check, \
+                mock.patch.object(main.rule_engine, "evaluate") as evaluate, \
+                mock.patch.object(main, "write_to_sinks", mock.AsyncMock()) as write:
+            run(main.handle_reading(sensor, data))
+        self.assertEqual(check.call_args.args[0].fields["temperature"], 30.0)
+        self.assertEqual(evaluate.call_args.args[0].fields["temperature"], 30.0)
+        self.assertAlmostEqual(write.call_args.args[0].fields["temperature"], 86.0)
+        self.assertAlmostEqual(main.latest_readings["fake"].fields["temperature"], 86.0)
+
+
+if __name__ == "__main__":
+    unittest.main()
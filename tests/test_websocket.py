@@ -0,0 +1,343 @@
+import asyncio
+import json
+import unittest
+from unittest import mock
+from auth import Chain, StaticToken
+from history import History
+import hub
+import main
+from fakes import FakeRequest, FakeSensor, FakeStream, FakeTransport, FakeWebSocket, run, until
+
+
+def frames(socket):
+    return [json.loads(frame) for frame in socket.sent]
+
+
+class ConnectionTest(unittest.TestCase):
+    """Runs the real /ws and /stream handlers against fake transports."""
+    def setUp(self):
+        self.app = {"draining": False, "authenticator": None,
+                    "sensors": [FakeSensor("DHT22"), FakeSensor("BMP280")]}
+        main.hub.clients.clear()
+        main.hub.queue.clear()
+
+    def tearDown(self):
+        self.assertEqual(main.hub.clients, set())
+
+    async def connect(self, socket, token=None, until_sent=1):
+        with mock.patch.object(main.web, "WebSocketResponse", lambda **options: socket):
+            handler = asyncio.create_task(main.websocket_handler(FakeRequest(self.app, "/ws", token=token),
+                                                                 legacy=False))
+            # Registered means the handler is past creating its socket
+            await until(lambda: main.hub.clients and len(socket.sent) >= until_sent or handler.done())
+        return handler
+
+
+class HelloTest(ConnectionTest):
+    def test_hello_is_the_first_frame(self):
+        socket = FakeWebSocket()
+
+        async def scenario():
+            handler = await self.connect(socket)
+            socket.disconnect()
+            await handler
+
+        run(scenario())
+        hello = frames(socket)[0]
+        self.assertEqual(hello["type"], "hello")
+        self.assertEqual(hello["version"], main.VERSION_INFO["version"])
+        self.assertEqual(hello["sensors"], [{"name": "DHT22", "type": "dht22"}, {"name": "BMP280", "type": "bmp280"}])
+        self.assertEqual(hello["heartbeat_interval"], main.HEARTBEAT_INTERVAL)
+
+    def test_hello_on_every_connection(self):
+        sockets = [FakeWebSocket(), FakeWebSocket()]
+
+        async def scenario():
+            for socket in sockets:
+                handler = await self.connect(socket)
+                socket.disconnect()
+                await handler
+
+        run(scenario())
+        self.assertEqual([frames(socket)[0]["type"] for socket in sockets], ["hello", "hello"])
+
+    def test_hello_follows_authentication(self):
+        self.app["authenticator"] = Chain([StaticToken("secret")])
+        socket = FakeWebSocket()
+
+        async def scenario():
+            handler = await self.connect(socket, until_sent=0)
+            await asyncio.sleep(0.01)
+            # Nothing, not even hello, until a token is accepted
+            self.assertEqual(socket.sent, [])
+            socket.receive(json.dumps({"type": "auth", "token": "secret"}))
+            await until(lambda: len(socket.sent) >= 2)
+            socket.disconnect()
+            await handler
+
+        run(scenario())
+        self.assertEqual([(frame["type"], frame.get("status")) for frame in frames(socket)],
+                         [("auth", "accepted"), ("hello", None)])
+
+    def test_hello_with_token_on_upgrade(self):
+        self.app["authenticator"] = Chain([StaticToken("secret")])
+        socket = FakeWebSocket()
+
+        async def scenario():
+            handler = await self.connect(socket, token="secret", until_sent=2)
+            socket.disconnect()
+            await handler
+
+        run(scenario())
+        self.assertEqual([frame["type"] for frame in frames(socket)], ["auth", "hello"])
+
+    def test_sse_hello_is_the_first_event(self):
+        stream = FakeStream()
+        request = FakeRequest(self.app, "/api/stream")
+        request.transport = FakeTransport()
+
+        async def scenario():
+            with mock.patch.object(main.web, "StreamResponse", lambda headers: stream), \
+                    mock.patch.object(main, "SSE_CHECK_INTERVAL", 0.01):
+                handler = asyncio.create_task(main.stream_handler(request))
+                await until(lambda: stream.events)
+                request.transport.closing = True
+                await handler
+
+        run(scenario())
+        self.assertEqual(json.loads(stream.events[0])["type"], "hello")
+
+
+class ClientLimitTest(ConnectionTest):
+    def test_stream_clients_dont_count(self):
+        async def send(message):
+            pass
+
+        streams = [main.hub.register(send, remote="127.0.0.1", kind="sse") for _ in range(2)]
+        first, second = FakeWebSocket(), FakeWebSocket()
+
+        async def scenario():
+            with mock.patch.object(main, "WS_MAX_CLIENTS", 1):
+                handler = await self.connect(first)
+                self.assertFalse(handler.done())
+                rejected = await self.connect(second, until_sent=0)
+                self.assertEqual((await rejected).status, 429)
+                first.disconnect()
+                await handler
+
+        try:
+            with self.assertLogs(main.logger, "WARNING"):
+                run(scenario())
+        finally:
+            for stream in streams:
+                main.hub.unregister(stream)
+        self.assertEqual(frames(first)[0]["type"], "hello")
+
+class LeakTest(ConnectionTest):
+    """Every way a connection ends must leave no task or client behind."""
+    ROUNDS = 50
+
+    async def settle(self, baseline):
+        await until(lambda: len(asyncio.all_tasks()) <= baseline)
+        await asyncio.sleep(0.01)
+        self.assertEqual(len(asyncio.all_tasks()), baseline)
+
+    def test_client_disconnects(self):
+        async def scenario():
+            baseline = len(asyncio.all_tasks())
+            for _ in range(self.ROUNDS):
+                socket = FakeWebSocket()
+                handler = await self.connect(socket)
+                socket.disconnect()
+                await handler
+            await self.settle(baseline)
+
+        run(scenario())
+
+    def test_write_fails(self):
+        async def scenario():
+            baseline = len(asyncio.all_tasks())
+            sockets = []
+            for _ in range(self.ROUNDS):
+                socket = FakeWebSocket()
+                socket.broken = True
+                sockets.append(socket)
+                # The failed hello write closes the socket, which ends the read loop
+                await (await self.connect(socket, until_sent=0))
+            await self.settle(baseline)
+            self.assertEqual({socket.close_calls for socket in sockets}, {1})
+
+        run(scenario())
+
+    def test_server_shutdown(self):
+        async def scenario():
+            baseline = len(asyncio.all_tasks())
+            sockets = [FakeWebSocket() for _ in range(self.ROUNDS)]
+            handlers = [await self.connect(socket) for socket in sockets]
+            self.assertEqual(len(main.hub.clients), self.ROUNDS)
+            # Shutdown closing the clients and their writers noticing at the same time close each only once
+            for socket in sockets:
+                socket.broken = True
+            main.hub.fan_out({"type": "heartbeat"}, None)
+            await main.hub.close_all()
+            await asyncio.gather(*handlers)
+            await self.settle(baseline)
+            self.assertEqual({socket.close_calls for socket in sockets}, {1})
+
+        run(scenario())
+
+    def test_nothing_queued_after_disconnect(self):
+        socket = FakeWebSocket()
+
+        async def scenario():
+            handler = await self.connect(socket)
+            client = next(iter(main.hub.clients))
+            socket.disconnect()
+            await handler
+            client.enqueue("late")
+            self.assertTrue(client.send_queue.empty())
+
+        run(scenario())
+
+
+class BroadcastTest(ConnectionTest):
+    def test_mixed_healthy_and_failing(self):
+        healthy = [FakeWebSocket() for _ in range(5)]
+        failing = [FakeWebSocket() for _ in range(5)]
+
+        async def scenario():
+            hub = asyncio.create_task(main.hub.run())
+            # Interleaved, so a failure is dealt with in the middle of a fan-out
+            handlers = [await self.connect(socket) for pair in zip(healthy, failing) for socket in pair]
+            for socket in failing:
+                socket.broken = True
+            for n in range(3):
+                main.hub.broadcast({"type": "heartbeat", "n": n})
+            await until(lambda: all(len(socket.sent) == 4 for socket in healthy))
+            await until(lambda: len(main.hub.clients) == len(healthy))
+            await asyncio.gather(*(handlers[i] for i in range(1, len(handlers), 2)))
+            self.assertEqual({socket.close_calls for socket in healthy}, {0})
+            for socket in healthy:
+                socket.disconnect()
+            await asyncio.gather(*handlers)
+            hub.cancel()
+
+        run(scenario())
+        for socket in healthy:
+            self.assertEqual([frame.get("n") for frame in frames(socket)], [None, 0, 1, 2])
+        self.assertEqual({socket.close_calls for socket in failing}, {1})
+
+    def test_failed_client_gets_nothing_more(self):
+        sockets = [FakeWebSocket(), FakeWebSocket()]
+
+        async def scenario():
+            handlers = [await self.connect(socket) for socket in sockets]
+            client = next(c for c in main.hub.clients if c.send == sockets[0].send_str)
+            sockets[0].broken = True
+            main.hub.fan_out({"type": "heartbeat"}, None)
+            await handlers[0]
+            self.assertNotIn(client, main.hub.clients)
+            main.hub.fan_out({"type": "heartbeat"}, None)
+            await until(lambda: len(sockets[1].sent) == 3)
+            self.assertTrue(client.send_queue.empty())
+            sockets[1].disconnect()
+            await handlers[1]
+
+        run(scenario())
+        self.assertEqual(len(sockets[1].sent), 3)
+
+
+class ChunkedWebSocket(FakeWebSocket):
+    """Writes each frame in two halves with a yield in between, as a real
+    transport may; two writers at once would interleave their halves.
+    """
+    def __init__(self):
+        super().__init__()
+        self.stream = ""
+        self.writing = False
+        self.overlapped = False
+
+    async def send_str(self, data: str):
+        if self.writing:
+            self.overlapped = True
+        self.writing = True
+        half = len(data) // 2
+        self.stream += data[:half]
+        await asyncio.sleep(0)
+        self.stream += data[half:] + "\n"
+        self.writing = False
+        await super().send_str(data)
+
+
+class SerializationTest(ConnectionTest):
+    def test_broadcast_during_history_reply(self):
+        sensor = self.app["sensors"][0]
+        history = History(500)
+        for i in range(200):
+            history.add(sensor.name(), main.SensorData("dht22", {"temperature": 20.0 + i / 100},
+                                                       timestamp=sensor.clock.now()))
+        socket = ChunkedWebSocket()
+
+        async def scenario():
+            with mock.patch.object(main, "history", history), \
+                    mock.patch.dict(main.hub.handlers, {"history": lambda m: main.history_reply(self.app, m)}):
+                hub = asyncio.create_task(main.hub.run())
+                handler = await self.connect(socket)
+                # Within the client's send buffer, which would otherwise drop some
+                for i in range(10):
+                    socket.receive(json.dumps({"type": "history", "sensor": "dht22", "n": 200, "id": i}))
+                    main.hub.publish_reading({"sensor_type": "dht22", "fields": {"temperature": 21.0}}, "dht22")
+                    main.hub.broadcast({"type": "heartbeat", "n": i})
+                    await asyncio.sleep(0)
+                await until(lambda: len(socket.sent) == 31)
+                socket.disconnect()
+                await handler
+                hub.cancel()
+
+        run(scenario())
+        self.assertFalse(socket.overlapped)
+        lines = socket.stream.splitlines()
+        self.assertEqual(lines, socket.sent)
+        kinds = [json.loads(line)["type"] for line in lines]
+        self.assertEqual((kinds.count("history"), kinds.count("reading"), kinds.count("heartbeat")), (10, 10, 10))
+        replies = [json.loads(line) for line in lines if json.loads(line)["type"] == "history"]
+        self.assertEqual([len(reply["data"]) for reply in replies], [200] * 10)
+
+
+
+class HistoryRequestTest(ConnectionTest):
+    def request(self, *messages, handler=None):
+        socket = FakeWebSocket()
+        handler = handler or (lambda m: main.history_reply(self.app, m))
+
+        async def scenario():
+            with mock.patch.object(main, "history", History(10)), \
+                    mock.patch.dict(main.hub.handlers, {"history": handler}):
+                connection = await self.connect(socket)
+                for message in messages:
+                    socket.receive(json.dumps(message))
+                await until(lambda: len(socket.sent) > len(messages))
+                # Still connected after the bad requests
+                self.assertFalse(connection.done())
+                socket.disconnect()
+                await connection
+
+        run(scenario())
+        return frames(socket)[1:]
+
+    def test_field_must_be_a_string(self):
+        replies = self.request({"type": "history", "sensor": "dht22", "field": ["x"], "id": 1},
+                               {"type": "history", "sensor": "dht22", "field": "temperature", "id": 2})
+        self.assertEqual(replies[0], {"type": "history", "sensor": "dht22", "error": "field must be a string", "id": 1})
+        self.assertEqual((replies[1]["id"], replies[1]["data"]), (2, []))
+
+    def test_failing_handler_gets_an_error_reply(self):
+        def broken(message):
+            raise KeyError("field")
+
+        with self.assertLogs(hub.logger, "ERROR"):
+            replies = self.request({"type": "history", "sensor": "dht22", "id": 7}, handler=broken)
+        self.assertEqual(replies, [{"type": "history", "error": "'field'", "id": 7}])
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,60 @@
+# units.py
+"""Unit conversion for sensor fields.
+
+Each dimension maps its units to a pair of functions converting to and
+from a base unit, so adding a unit is one entry in the table.
+"""
+from typing import Callable, Dict, Optional, Tuple
+
+Conversion = Tuple[Callable[[float], float], Callable[[float], float]]
+
+TEMPERATURE: Dict[str, Conversion] = {
+    "C": (lambda v: v, lambda v: v),
+    "F": (lambda v: (v - 32) * 5 / 9, lambda v: v * 9 / 5 + 32),
+    "K": (lambda v: v - 273.15, lambda v: v + 273.15),
+}
+
+# Base unit is hPa
+PRESSURE: Dict[str, Conversion] = {
+    "hPa": (lambda v: v, lambda v: v),
+    "kPa": (lambda v: v * 10, lambda v: v / 10),
+    "inHg": (lambda v: v * 33.8639, lambda v: v / 33.8639),
+    "mmHg": (lambda v: v * 1.333224, lambda v: v / 1.333224),
+}
+
+DIMENSIONS: Dict[str, Dict[str, Conversion]] = {
+    "temperature": TEMPERATURE,
+    "pressure": PRESSURE,
+}
+
+ALIASES = {"°C": "C", "°F": "F", "°K": "K"}
+
+# How units are written in metadata and the API
+DISPLAY = {"C": "°C", "F": "°F"}
+
+
+def normalize(unit: str) -> str:
+    return ALIASES.get(unit, unit)
+
+
+def dimension(unit: str) -> Optional[str]:
+    unit = normalize(unit)
+    for name, units in DIMENSIONS.items():
+        if unit in units:
+            return name
+    return None
+
+
+def convert(value: float, source: str, target: str) -> float:
+    source, target = normalize(source), normalize(target)
+    if source == target:
+        return value
+    kind = dimension(source)
+    if kind is None or target not in DIMENSIONS[kind]:
+        raise ValueError(f"can't convert {source} to {target}")
+    units = DIMENSIONS[kind]
+    return units[target][1](units[source][0](value))
+
+
+def display(unit: str) -> str:
+    return DISPLAY.get(normalize(unit), unit)
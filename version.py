@@ -0,0 +1,28 @@
+# version.py
+import os
+import subprocess
+
+# Release builds overwrite these (see README), or set them via the environment
+VERSION = "dev"
+GIT_COMMIT = ""
+BUILD_TIME = ""
+
+
+def _git_commit() -> str:
+    # Running from a checkout: ask git
+    try:
+        return subprocess.run(
+            ["git", "rev-parse", "--short", "HEAD"],
+            cwd=os.path.dirname(os.path.abspath(__file__)),
+            capture_output=True, text=True, timeout=2, check=True
+        ).stdout.strip()
+    except Exception:
+        return "unknown"
+
+
+def version_info():
+    return {
+        "version": os.getenv("IOTGO_VERSION", VERSION),
+        "commit": os.getenv("IOTGO_COMMIT", GIT_COMMIT) or _git_commit(),
+        "build_time": os.getenv("IOTGO_BUILD_TIME", BUILD_TIME) or "unknown",
+    }